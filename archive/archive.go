@@ -0,0 +1,380 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DEFAULT_MAX_SIZE caps how much an archive can unpack to, so a zip bomb can't fill the disk
+	DEFAULT_MAX_SIZE  = 1 << 30
+	DEFAULT_MAX_FILES = 10000
+)
+
+// Options tweak how an archive is extracted. The zero value is fine.
+type Options struct {
+	// StripComponents drops this many leading directories from every path, like tar's --strip-components
+	StripComponents int
+
+	MaxSize  int64 // total uncompressed bytes, DEFAULT_MAX_SIZE if 0
+	MaxFiles int   // DEFAULT_MAX_FILES if 0
+
+	// Progress is called as data is written. total is 0 when the archive doesn't say up front (tar.gz).
+	Progress func(done int64, total int64)
+}
+
+// Result is what got extracted
+type Result struct {
+	// Root is the archive's single top level directory if it has one, otherwise dest
+	Root string
+
+	// Files lists every extracted path, directories included
+	Files []string
+}
+
+// Extract unpacks a .zip or .tar.gz/.tgz into dest, going by the file name
+func Extract(filename string, dest string, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	x := &extractor{
+		dest:     filepath.Clean(dest),
+		opts:     opts,
+		maxSize:  opts.MaxSize,
+		maxFiles: opts.MaxFiles,
+		result:   &Result{},
+		tops:     map[string]bool{},
+	}
+	if x.maxSize <= 0 {
+		x.maxSize = DEFAULT_MAX_SIZE
+	}
+	if x.maxFiles <= 0 {
+		x.maxFiles = DEFAULT_MAX_FILES
+	}
+
+	if err := os.MkdirAll(x.dest, 0777); err != nil {
+		return nil, err
+	}
+
+	var err error
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = x.zip(filename)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = x.tarGz(filename)
+	default:
+		err = fmt.Errorf("%s: unsupported archive type", filepath.Base(filename))
+	}
+	// check the links even when something else failed, so bad ones don't get left lying around
+	if linkErr := x.checkLinks(); err == nil {
+		err = linkErr
+	}
+	if err != nil {
+		return x.result, err
+	}
+
+	x.result.Root = x.dest
+	if len(x.tops) == 1 {
+		for top, isDir := range x.tops {
+			if isDir {
+				x.result.Root = filepath.Join(x.dest, top)
+			}
+		}
+	}
+	return x.result, nil
+}
+
+type extractor struct {
+	dest     string
+	opts     *Options
+	maxSize  int64
+	maxFiles int
+
+	written int64
+	total   int64
+	result  *Result
+
+	// top level entries, and whether they're directories
+	tops map[string]bool
+
+	// every symlink made, checked again once everything's out
+	links []string
+}
+
+// target works out where an entry goes, or "" if it's stripped away entirely
+func (x *extractor) target(name string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "/"))
+	parts := strings.Split(name, "/")
+	if len(parts) <= x.opts.StripComponents || name == "." {
+		return "", nil
+	}
+	parts = parts[x.opts.StripComponents:]
+
+	fpath := filepath.Join(x.dest, filepath.FromSlash(strings.Join(parts, "/")))
+	if !strings.HasPrefix(fpath, x.dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s: illegal filepath", name)
+	}
+	return fpath, nil
+}
+
+func (x *extractor) add(fpath string, isDir bool) error {
+	if len(x.result.Files) >= x.maxFiles {
+		return fmt.Errorf("archive has too many files (over %d)", x.maxFiles)
+	}
+	x.result.Files = append(x.result.Files, fpath)
+
+	rel, _ := filepath.Rel(x.dest, fpath)
+	top, _, nested := strings.Cut(filepath.ToSlash(rel), "/")
+	x.tops[top] = x.tops[top] || isDir || nested
+	return nil
+}
+
+func (x *extractor) dir(fpath string) error {
+	if err := x.add(fpath, true); err != nil {
+		return err
+	}
+	return x.mkdirs(fpath)
+}
+
+// mkdirs creates dir and anything missing between it and dest. Each link can look harmless on its own
+// and a chain of them still lead out of dest (z -> ., e -> z/..), so nothing is ever written through a symlink.
+func (x *extractor) mkdirs(dir string) error {
+	rel, err := filepath.Rel(x.dest, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	cur := x.dest
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(cur, 0777); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("%s: path goes through a symlink", dir)
+		case !info.IsDir():
+			return fmt.Errorf("%s: not a directory", cur)
+		}
+	}
+	return nil
+}
+
+// checkLinks makes sure every symlink really ends up inside dest once they're all in place,
+// whatever the chain of links in between
+func (x *extractor) checkLinks() error {
+	if len(x.links) == 0 {
+		return nil
+	}
+	realDest, err := filepath.EvalSymlinks(x.dest)
+	if err != nil {
+		return err
+	}
+
+	var bad error
+	for _, l := range x.links {
+		resolved, err := filepath.EvalSymlinks(l)
+		switch {
+		case err != nil:
+			bad = fmt.Errorf("%s: broken symlink", l)
+		case resolved != realDest && !strings.HasPrefix(resolved, realDest+string(os.PathSeparator)):
+			bad = fmt.Errorf("%s: symlink points outside the archive", l)
+		default:
+			continue
+		}
+		// don't leave it around for something else to follow
+		os.Remove(l)
+	}
+	return bad
+}
+
+// file writes r to fpath, stopping as soon as the size limit is passed, whatever the headers claimed
+func (x *extractor) file(fpath string, mode os.FileMode, r io.Reader) error {
+	if err := x.add(fpath, false); err != nil {
+		return err
+	}
+	if err := x.mkdirs(filepath.Dir(fpath)); err != nil {
+		return err
+	}
+	// an earlier entry might have put a link here, replace it rather than writing through it
+	if info, err := os.Lstat(fpath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(fpath); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			x.written += int64(n)
+			if x.written > x.maxSize {
+				return fmt.Errorf("archive unpacks to more than %d bytes", x.maxSize)
+			}
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			if x.opts.Progress != nil {
+				x.opts.Progress(x.written, x.total)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// symlink only allows links that stay inside dest, at least on paper. checkLinks has the final say.
+func (x *extractor) symlink(fpath string, link string) error {
+	if filepath.IsAbs(link) || strings.HasPrefix(link, "/") {
+		return fmt.Errorf("%s: absolute symlink", fpath)
+	}
+	resolved := filepath.Join(filepath.Dir(fpath), filepath.FromSlash(link))
+	if resolved != x.dest && !strings.HasPrefix(resolved, x.dest+string(os.PathSeparator)) {
+		return fmt.Errorf("%s: symlink points outside the archive", fpath)
+	}
+
+	if err := x.add(fpath, false); err != nil {
+		return err
+	}
+	if err := x.mkdirs(filepath.Dir(fpath)); err != nil {
+		return err
+	}
+	os.Remove(fpath)
+	if err := os.Symlink(link, fpath); err != nil {
+		return err
+	}
+	x.links = append(x.links, fpath)
+	return nil
+}
+
+func (x *extractor) zip(filename string) error {
+	r, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the headers can lie, but if they admit to being too big there's no point starting
+	for _, f := range r.File {
+		x.total += int64(f.UncompressedSize64)
+	}
+	if x.total > x.maxSize {
+		return fmt.Errorf("archive unpacks to more than %d bytes", x.maxSize)
+	}
+
+	for _, f := range r.File {
+		fpath, err := x.target(f.Name)
+		if err != nil {
+			return err
+		}
+		if fpath == "" {
+			continue
+		}
+
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			err = x.dir(fpath)
+		case mode&os.ModeSymlink != 0:
+			err = x.zipSymlink(f, fpath)
+		case mode.IsRegular():
+			err = x.zipFile(f, fpath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipFile(f *zip.File, fpath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return x.file(fpath, f.Mode(), rc)
+}
+
+func (x *extractor) zipSymlink(f *zip.File, fpath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	link, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(fpath, string(link))
+}
+
+func (x *extractor) tarGz(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fpath, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if fpath == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(fpath)
+		case tar.TypeSymlink:
+			err = x.symlink(fpath, hdr.Linkname)
+		case tar.TypeReg:
+			err = x.file(fpath, hdr.FileInfo().Mode(), tr)
+		default:
+			// hard links, devices etc. have no business in a release archive
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is one thing to put in a test archive. link makes it a symlink, a trailing / on name a directory.
+type entry struct {
+	name string
+	body string
+	link string
+}
+
+func writeTarGz(t *testing.T, filename string, entries []entry) {
+	t.Helper()
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644}
+		switch {
+		case e.link != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, filename string, entries []entry) {
+	t.Helper()
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch {
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		case e.name[len(e.name)-1] == '/':
+			hdr.SetMode(os.ModeDir | 0755)
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// both builds the same entries as a zip and a tar.gz, and runs fn on each
+func both(t *testing.T, entries []entry, fn func(t *testing.T, filename string)) {
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		ext := ext
+		t.Run(ext, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test"+ext)
+			if ext == ".zip" {
+				writeZip(t, filename, entries)
+			} else {
+				writeTarGz(t, filename, entries)
+			}
+			fn(t, filename)
+		})
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"dot dot", []entry{{name: "../escaped.txt", body: "x"}}},
+		{"nested dot dot", []entry{{name: "a/../../escaped.txt", body: "x"}}},
+		{"absolute symlink", []entry{{name: "abs", link: "/etc"}}},
+		{"relative symlink out", []entry{{name: "up", link: "../"}}},
+		{"write through symlink", []entry{
+			{name: "z", link: "."},
+			{name: "z/escaped.txt", body: "x"},
+		}},
+		{"symlink chain", []entry{
+			{name: "z", link: "."},
+			{name: "esc", link: "z/.."},
+			{name: "esc/escaped.txt", body: "x"},
+		}},
+		{"longer symlink chain", []entry{
+			{name: "a/z", link: "."},
+			{name: "a/e1", link: "z/.."},
+			{name: "a/e2", link: "e1/.."},
+			{name: "a/e2/escaped.txt", body: "x"},
+		}},
+		{"dangling link filled in later", []entry{
+			{name: "esc", link: "b/.."},
+			{name: "b", link: "."},
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			both(t, tt.entries, func(t *testing.T, filename string) {
+				parent := t.TempDir()
+				dest := filepath.Join(parent, "dest")
+
+				if _, err := Extract(filename, dest, nil); err == nil {
+					t.Error("expected an error")
+				}
+				if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+					t.Error("file was written outside dest")
+				}
+				if _, err := os.Stat(filepath.Join(dest, "esc")); err == nil {
+					t.Error("escaping link was left behind")
+				}
+			})
+		})
+	}
+}
+
+func TestExtractAllowsInsideSymlinks(t *testing.T) {
+	entries := []entry{
+		{name: "app/"},
+		{name: "app/Versions/A/lib.so", body: "lib"},
+		{name: "app/Versions/Current", link: "A"},
+		{name: "app/lib.so", link: "Versions/Current/lib.so"},
+	}
+	both(t, entries, func(t *testing.T, filename string) {
+		dest := t.TempDir()
+		res, err := Extract(filename, dest, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Root != filepath.Join(dest, "app") {
+			t.Errorf("root = %s", res.Root)
+		}
+		data, err := os.ReadFile(filepath.Join(dest, "app", "lib.so"))
+		if err != nil || string(data) != "lib" {
+			t.Errorf("lib.so through links = %q, %v", data, err)
+		}
+	})
+}
+
+func TestExtractLimits(t *testing.T) {
+	entries := []entry{
+		{name: "a.txt", body: "0123456789"},
+		{name: "b.txt", body: "0123456789"},
+	}
+	both(t, entries, func(t *testing.T, filename string) {
+		if _, err := Extract(filename, t.TempDir(), &Options{MaxSize: 15}); err == nil {
+			t.Error("size limit not enforced")
+		}
+		if _, err := Extract(filename, t.TempDir(), &Options{MaxFiles: 1}); err == nil {
+			t.Error("file limit not enforced")
+		}
+	})
+}
+
+func TestExtractStripComponents(t *testing.T) {
+	entries := []entry{
+		{name: "top/"},
+		{name: "top/sub/file.txt", body: "hi"},
+	}
+	both(t, entries, func(t *testing.T, filename string) {
+		dest := t.TempDir()
+		if _, err := Extract(filename, dest, &Options{StripComponents: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "sub", "file.txt")); err != nil {
+			t.Error(err)
+		}
+	})
+}
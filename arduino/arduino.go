@@ -3,8 +3,8 @@ package arduino
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"time"
 
@@ -15,29 +15,20 @@ import (
 	"github.com/arduino/arduino-cli/commands/lib"
 	"github.com/arduino/arduino-cli/commands/upload"
 	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
-	"github.com/reyemxela/LEDControllerUpdater/layout"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/ports"
 	"github.com/reyemxela/LEDControllerUpdater/state"
-	"github.com/reyemxela/LEDControllerUpdater/utils"
+	"github.com/reyemxela/LEDControllerUpdater/verify"
 	"go.bug.st/serial"
 )
 
-const (
-	FQBN           = "arduino:avr:nano:cpu=atmega328"
-	FQBNold        = "arduino:avr:nano:cpu=atmega328old"
-	ZIP_URL_PREFIX = "https://github.com/wingnut-tech/LEDController/archive/refs/tags/"
-)
-
-var neededLibraries = [][]string{
-	{"FastLED", "3.4.0"},
-	{"Adafruit BMP280 Library", "2.3.0"},
-}
-
-func CheckLibraries(instance *rpc.Instance) error {
-	for _, libs := range neededLibraries {
-		if err := lib.LibraryInstall(context.Background(), &rpc.LibraryInstallRequest{
+// CheckLibraries installs the given libraries into the normal arduino libraries folder
+func CheckLibraries(ctx context.Context, instance *rpc.Instance, libs []firmware.Library) error {
+	for _, l := range libs {
+		if err := lib.LibraryInstall(ctx, &rpc.LibraryInstallRequest{
 			Instance: instance,
-			Name:     libs[0],
-			Version:  libs[1],
+			Name:     l.Name,
+			Version:  l.Version,
 		}, output.NewNullDownloadProgressCB(), output.NewNullTaskProgressCB()); err != nil {
 			return err
 		}
@@ -45,8 +36,8 @@ func CheckLibraries(instance *rpc.Instance) error {
 	return nil
 }
 
-func CheckCore(instance *rpc.Instance) error {
-	if _, err := core.PlatformInstall(context.Background(), &rpc.PlatformInstallRequest{
+func CheckCore(ctx context.Context, instance *rpc.Instance) error {
+	if _, err := core.PlatformInstall(ctx, &rpc.PlatformInstallRequest{
 		Instance:        instance,
 		PlatformPackage: "arduino",
 		Architecture:    "avr",
@@ -56,137 +47,189 @@ func CheckCore(instance *rpc.Instance) error {
 	return nil
 }
 
-func WatchPorts(s *state.State, callback func()) {
-	eventsChan, _, err := board.Watch(&rpc.BoardListWatchRequest{Instance: s.Instance})
+func WatchPorts(s *state.State) {
+	eventsChan, _, err := board.Watch(&rpc.BoardListWatchRequest{Instance: s.Instance()})
 	if err != nil {
 		s.SetStatus(err.Error())
 	}
 
 	// loop forever listening for board.Watch to give us events
 	for event := range eventsChan {
-		port := event.Port.Port
-		addr := port.Address
 		if event.EventType == "add" {
-			s.Ports[addr] = port
-			s.CurrentPort = addr
+			s.AddPort(ports.Describe(event.Port, boardIDs(s)))
 		} else {
-			// if the port was in the list, remove it
-			if _, ok := s.Ports[addr]; ok {
-				delete(s.Ports, addr)
-			}
-			// if the unplugged board was our current one, grab the first available (if there is one)
-			if s.CurrentPort == addr {
-				if len(s.Ports) > 0 {
-					for p := range s.Ports {
-						s.CurrentPort = p
-						break
-					}
-				} else {
-					s.CurrentPort = ""
-				}
-			}
+			s.RemovePort(event.Port.Port.Address)
 		}
-		callback()
 	}
 }
 
-func DoFlash(s *state.State) error {
-	s.Ready.NotFlashing = false
-	defer func() {
-		s.Ready.NotFlashing = true
-	}()
+// boardIDs are the usb ids of every board the current firmware knows about
+func boardIDs(s *state.State) []string {
+	ids := []string{}
+	for _, b := range s.Firmware().Boards {
+		ids = append(ids, b.USBIDs...)
+	}
+	return ids
+}
+
+// DoFlash flashes the current selection. The caller is responsible for marking the state as flashing.
+func DoFlash(ctx context.Context, s *state.State) error {
+	if s.Port() == nil {
+		return fmt.Errorf("no port selected")
+	}
 
-	if s.CustomSelected {
-		return CompileAndFlash(s)
+	if s.CustomSelected() {
+		return CompileAndFlash(ctx, s)
 	} else {
-		return DownloadAndFlash(s)
+		return DownloadAndFlash(ctx, s)
 	}
 }
 
-func CompileAndFlash(s *state.State) error {
-	ver := s.CurrentVersion
+func CompileAndFlash(ctx context.Context, s *state.State) error {
+	ver := s.CurrentVersion()
+	release := s.Release()
+	if release == nil {
+		return fmt.Errorf("no version selected")
+	}
+	customLayout := s.CustomLayout()
+	fw, board := s.Firmware(), s.Board()
+	if fw.Schema == nil {
+		return fmt.Errorf("%s doesn't support custom layouts", fw.Name)
+	}
 
-	newFolder := filepath.Join(s.TmpDir, ver)
-	layoutFile := filepath.Join(newFolder, "layout.h")
-	exportDir := filepath.Join(newFolder, "build")
+	// the sketch name has to match its folder, and versions can overlap between firmwares
+	sketchName := fw.Name + "_" + ver
 
-	// if the ver folder doesn't already exist, download and unzip
-	if _, err := os.Stat(newFolder); err != nil {
-		s.SetStatus("Downloading " + ver)
-		zipFile := ver + ".zip"
-		zipUrl := ZIP_URL_PREFIX + zipFile
+	layoutData := fw.Schema.Generate(&customLayout)
 
-		err := utils.DownloadFile(zipFile, zipUrl)
-		if err != nil {
-			return err
-		}
+	srcDir, err := prepareSource(ctx, s, fw, release, sketchName)
+	if err != nil {
+		return err
+	}
 
-		fileNames, err := utils.UnzipFile(zipFile, s.TmpDir)
-		if err != nil {
-			return err
-		}
+	libs, err := librariesFor(fw, ver, srcDir)
+	if err != nil {
+		return err
+	}
 
-		// assume first element is parent directory
-		os.Rename(fileNames[0], newFolder)
+	// the same layout might have been built before, skip straight to flashing if so
+	key, err := buildKey(s.Instance(), fw, ver, board, layoutData, libs)
+	if err != nil {
+		return err
+	}
+	if hexFile, ok := cachedHex(s, key); ok {
+		// it was already checked when it was built, this is just for show
+		s.SetBuildSize(cachedSize(s, key))
+		s.SetStatus("Flashing custom " + ver + " layout...")
+		return FlashHex(ctx, hexFile, s.Instance(), s.Port(), board)
+	}
 
-		// rename .ino file because the arduino tools demand it matches the folder name
-		os.Rename(
-			filepath.Join(newFolder, "LEDController.ino"),
-			filepath.Join(newFolder, ver+".ino"),
-		)
+	s.SetStatus("Checking libraries for " + ver + "...")
+	libPaths, err := pinLibraries(ctx, s.Instance(), libs)
+	if err != nil {
+		return err
 	}
-	s.SetStatus("Compiling custom " + ver + " layout...")
 
-	// write out the custom layout into layout.h
-	err := os.WriteFile(layoutFile, layout.GenerateCustomLayout(s.CustomLayout), os.ModePerm)
+	sketchDir, exportDir, err := prepareWorkspace(s, srcDir, sketchName, fw.Schema.File, layoutData)
 	if err != nil {
 		return err
 	}
 
-	if _, err := compile.Compile(context.Background(), &rpc.CompileRequest{
-		Instance:   s.Instance,
-		Fqbn:       FQBN,
-		SketchPath: newFolder,
+	s.SetStatus("Compiling custom " + ver + " layout...")
+	resp, err := compile.Compile(ctx, &rpc.CompileRequest{
+		Instance:   s.Instance(),
+		Fqbn:       board.FQBN,
+		SketchPath: sketchDir,
 		ExportDir:  exportDir,
-	}, io.Discard, io.Discard, nil, false); err != nil {
+		Library:    libPaths,
+	}, io.Discard, io.Discard, nil, false)
+	if err != nil {
+		return err
+	}
+
+	size := sizeReport(resp)
+	s.SetBuildSize(size)
+	warning, err := size.Check()
+	if err != nil {
 		return err
 	}
 
-	s.SetStatus("Flashing custom " + ver + " layout...")
-	if err := FlashHex(filepath.Join(exportDir, ver+".ino.hex"), s.Instance, s.Ports[s.CurrentPort]); err != nil {
+	hexFile := filepath.Join(exportDir, sketchName+".ino.hex")
+	// a cache failure shouldn't stop the flash, it just means building again next time
+	storeHex(s, key, hexFile, size)
+
+	if warning != "" {
+		s.SetStatus("Warning: " + warning + ". Flashing custom " + ver + " layout...")
+	} else {
+		s.SetStatus("Flashing custom " + ver + " layout...")
+	}
+	if err := FlashHex(ctx, hexFile, s.Instance(), s.Port(), board); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func DownloadAndFlash(s *state.State) error {
-	ver, lay := s.CurrentVersion, s.CurrentLayout
-	hexFile := filepath.Join(s.TmpDir, lay)
-	hexURL := s.Versions[ver][lay]
-	if _, err := os.Stat(hexFile); err != nil {
-		s.SetStatus("Downloading " + lay)
-		if err := utils.DownloadFile(hexFile, hexURL); err != nil {
-			return err
+// sizeReport pulls program and memory usage out of a compile response. avr-size calls them "text" and "data".
+func sizeReport(resp *rpc.CompileResponse) *firmware.SizeReport {
+	r := &firmware.SizeReport{}
+	for _, section := range resp.GetExecutableSectionsSize() {
+		switch section.Name {
+		case "text":
+			r.Flash, r.FlashMax = section.Size, section.MaxSize
+		case "data":
+			r.RAM, r.RAMMax = section.Size, section.MaxSize
 		}
 	}
+	return r
+}
+
+func DownloadAndFlash(ctx context.Context, s *state.State) error {
+	release := s.Release()
+	if release == nil {
+		return fmt.Errorf("no version selected")
+	}
+
+	lay := s.CurrentLayout()
+	asset, ok := s.Layouts()[lay]
+	if !ok {
+		return fmt.Errorf("no layout selected")
+	}
+	hexFile := filepath.Join(s.TmpDir, asset.Name)
+
+	s.SetStatus("Checking " + asset.Name)
+	sum, err := release.Checksum(ctx, asset.Name)
+	if err != nil {
+		return err
+	}
+	// re-downloads the hex if the cached copy doesn't match
+	if err := verify.Download(ctx, hexFile, asset.URL, sum); err != nil {
+		return err
+	}
 	s.SetStatus("Flashing " + lay + "...")
-	if err := FlashHex(hexFile, s.Instance, s.Ports[s.CurrentPort]); err != nil {
+	board := s.Board()
+	if err := FlashHex(ctx, hexFile, s.Instance(), s.Port(), board); err != nil {
 		return err
 	}
 	return nil
 }
 
-func FlashHex(hexFile string, instance *rpc.Instance, port *rpc.Port) error {
-	bl := FQBNold
+// FlashHex uploads hexFile to the board on port, checking which bootloader it has first
+func FlashHex(ctx context.Context, hexFile string, instance *rpc.Instance, port *rpc.Port, board *firmware.Board) error {
+	if port == nil {
+		return fmt.Errorf("port disconnected")
+	}
+
+	bl := board.OldBootloaderFQBN
 
-	tb, err := testBootloaderType(port.Address, 115200)
+	tb, err := testBootloaderType(ctx, port.Address, 115200)
 	if err != nil {
 		return err
 	}
 	if tb {
-		bl = FQBN
+		bl = board.FQBN
 	} else {
-		tb, err := testBootloaderType(port.Address, 57600)
+		tb, err := testBootloaderType(ctx, port.Address, 57600)
 		if err != nil {
 			return err
 		}
@@ -195,7 +238,7 @@ func FlashHex(hexFile string, instance *rpc.Instance, port *rpc.Port) error {
 		}
 	}
 
-	if _, err := upload.Upload(context.Background(), &rpc.UploadRequest{
+	if _, err := upload.Upload(ctx, &rpc.UploadRequest{
 		Instance:   instance,
 		Fqbn:       bl,
 		SketchPath: filepath.Dir(hexFile),
@@ -208,14 +251,14 @@ func FlashHex(hexFile string, instance *rpc.Instance, port *rpc.Port) error {
 	return nil
 }
 
-func testBootloaderType(p string, b int) (bool, error) {
+func testBootloaderType(ctx context.Context, p string, b int) (bool, error) {
 	syncCmd := []byte{0x30, 0x20}
 	inSyncResp := []byte{0x14, 0x10}
 	delay := (250 * time.Millisecond)
 	shortDelay := (50 * time.Millisecond)
 	timeout := (250 * time.Millisecond)
 
-	port, err := serial.Open(p, &serial.Mode{BaudRate: b})
+	port, err := openPort(p, &serial.Mode{BaudRate: b})
 	if err != nil {
 		return false, err
 	}
@@ -235,6 +278,9 @@ func testBootloaderType(p string, b int) (bool, error) {
 	port.ResetInputBuffer()
 
 	for i := 0; i < 4; i++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
 		port.Write(syncCmd)
 		time.Sleep(shortDelay)
 
@@ -0,0 +1,220 @@
+package arduino
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/reyemxela/LEDControllerUpdater/archive"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+const (
+	BUNDLE_DATA_DIR = "data"
+	BUNDLE_LIB_DIR  = "libraries"
+)
+
+// bundleDirs maps the top-level folders inside a bundle to where they live on this machine
+func bundleDirs() map[string]string {
+	return map[string]string{
+		BUNDLE_DATA_DIR: configuration.Settings.GetString("directories.Data"),
+		BUNDLE_LIB_DIR:  filepath.Join(configuration.Settings.GetString("directories.User"), "libraries"),
+	}
+}
+
+// ExportBundle packs the installed arduino core, package indexes and libraries into a single zip,
+// which can be imported on a machine that can't reach the arduino index.
+func ExportBundle(s *state.State, filename string) error {
+	s.SetStatus("Exporting arduino bundle...")
+
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+
+	for prefix, dir := range bundleDirs() {
+		if err := addDirToZip(zw, dir, prefix); err != nil {
+			zw.Close()
+			return fmt.Errorf("bundle: %s: %s", prefix, err.Error())
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addDirToZip(zw *zip.Writer, dir string, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		// staging is just the download cache, no need to ship it
+		if d.IsDir() && rel == "staging" {
+			return filepath.SkipDir
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if d.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			// store symlinks as their target, same as the zip tool does
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write([]byte(target))
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// ImportBundle unpacks a bundle made by ExportBundle into the arduino directories,
+// then reloads the arduino instance so the new core and libraries get picked up.
+// It's all unpacked and checked in a staging folder first, so a bad bundle leaves the arduino directories alone.
+func ImportBundle(s *state.State, filename string) error {
+	s.SetStatus("Importing arduino bundle...")
+
+	dirs := bundleDirs()
+	if err := checkBundle(filename, dirs); err != nil {
+		return err
+	}
+
+	// next to the data dir, so moving things into place is just a rename
+	stage, err := os.MkdirTemp(filepath.Dir(dirs[BUNDLE_DATA_DIR]), ".bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	if _, err := archive.Extract(filename, filepath.Join(stage, "new"), nil); err != nil {
+		return err
+	}
+
+	// data first, the libraries folder can be inside it
+	done := []replaced{}
+	for _, prefix := range []string{BUNDLE_DATA_DIR, BUNDLE_LIB_DIR} {
+		entries, err := os.ReadDir(filepath.Join(stage, "new", prefix))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = os.MkdirAll(dirs[prefix], os.ModePerm)
+		}
+		for _, e := range entries {
+			if err != nil {
+				break
+			}
+			var r replaced
+			r, err = replace(filepath.Join(stage, "new", prefix, e.Name()), filepath.Join(dirs[prefix], e.Name()), filepath.Join(stage, "old", prefix, e.Name()))
+			if err == nil {
+				done = append(done, r)
+			}
+		}
+		if err != nil {
+			for i := len(done) - 1; i >= 0; i-- {
+				done[i].undo()
+			}
+			return fmt.Errorf("bundle: %w", err)
+		}
+	}
+
+	s.ReloadInstance()
+	return nil
+}
+
+// checkBundle makes sure everything in the zip goes in one of the bundle folders
+func checkBundle(filename string, dirs map[string]string) error {
+	r, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		prefix, rel, _ := strings.Cut(f.Name, "/")
+		if _, ok := dirs[prefix]; !ok || rel == "" {
+			return fmt.Errorf("%s: not an arduino bundle", filename)
+		}
+	}
+	return nil
+}
+
+// replaced is a file or folder swapped in from the bundle, with whatever it replaced kept in backup
+type replaced struct {
+	dest   string
+	backup string
+}
+
+// replace moves src to dest, moving anything already at dest to backup first
+func replace(src string, dest string, backup string) (replaced, error) {
+	r := replaced{dest: dest}
+	if _, err := os.Lstat(dest); err == nil {
+		if err := os.MkdirAll(filepath.Dir(backup), os.ModePerm); err != nil {
+			return r, err
+		}
+		if err := os.Rename(dest, backup); err != nil {
+			return r, err
+		}
+		r.backup = backup
+	}
+	if err := os.Rename(src, dest); err != nil {
+		r.undo()
+		return r, err
+	}
+	return r, nil
+}
+
+func (r replaced) undo() {
+	os.RemoveAll(r.dest)
+	if r.backup != "" {
+		os.Rename(r.backup, r.dest)
+	}
+}
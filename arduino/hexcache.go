@@ -0,0 +1,145 @@
+package arduino
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+const (
+	// HEX_CACHE_MAX_SIZE is how big the compiled hex cache can get before the least recently used ones go
+	HEX_CACHE_MAX_SIZE = 20 << 20
+)
+
+// buildKey identifies a custom build by everything that can change the compiled hex:
+// firmware version, layout, board, the pinned libraries and the core.
+// Only pinned libraries are used for the build, so whatever else is installed doesn't matter.
+func buildKey(instance *rpc.Instance, fw *firmware.Profile, ver string, board *firmware.Board, layoutData []byte, pinned []firmware.Library) (string, error) {
+	parts := []string{fw.Name, ver, board.FQBN, layoutHash(layoutData)}
+
+	coreVer, err := InstalledCore(instance)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, "core:arduino:avr@"+coreVer)
+
+	for _, l := range pinned {
+		parts = append(parts, "pin:"+l.Name+"@"+l.Version)
+	}
+	// a manifest in a different order is still the same build
+	sort.Strings(parts[5:])
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hexCacheDir(s *state.State) string {
+	return filepath.Join(s.TmpDir, cache.HEX_CACHE_DIR)
+}
+
+// cachedHex returns the cached hex for a build key, if there is one
+func cachedHex(s *state.State, key string) (string, bool) {
+	hexFile := filepath.Join(hexCacheDir(s), key+".hex")
+	if _, err := os.Stat(hexFile); err != nil {
+		return "", false
+	}
+	// bump it so pruning goes by last use rather than when it was built
+	now := time.Now()
+	os.Chtimes(hexFile, now, now)
+	return hexFile, true
+}
+
+// cachedSize returns the size report saved with a cached hex, or nil
+func cachedSize(s *state.State, key string) *firmware.SizeReport {
+	data, err := os.ReadFile(filepath.Join(hexCacheDir(s), key+".json"))
+	if err != nil {
+		return nil
+	}
+	size := &firmware.SizeReport{}
+	if err := json.Unmarshal(data, size); err != nil {
+		return nil
+	}
+	return size
+}
+
+// storeHex copies a freshly compiled hex (and its size report) into the cache, then trims the cache back under its size limit
+func storeHex(s *state.State, key string, hexFile string, size *firmware.SizeReport) error {
+	dir := hexCacheDir(s)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	if data, err := json.Marshal(size); err == nil {
+		os.WriteFile(filepath.Join(dir, key+".json"), data, 0666)
+	}
+
+	in, err := os.Open(hexFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(dir, key+".hex.tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, key+".hex")); err != nil {
+		return err
+	}
+
+	return pruneHexCache(dir, HEX_CACHE_MAX_SIZE)
+}
+
+// pruneHexCache removes the least recently used hexes until the cache fits in maxSize
+func pruneHexCache(dir string, maxSize int64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := []os.FileInfo{}
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+		total -= f.Size()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package arduino
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/arduino/arduino-cli/cli/output"
+	"github.com/arduino/arduino-cli/commands/lib"
+	"github.com/arduino/arduino-cli/configuration"
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+)
+
+const (
+	// LIBRARY_MANIFEST is an optional file in the firmware source listing the exact libraries it needs,
+	// dependencies included:
+	//	[{"name": "FastLED", "version": "3.4.0"}]
+	LIBRARY_MANIFEST = "libraries.json"
+
+	// LIBRARY_STORE_DIR holds every pinned library version side by side, under the arduino data dir
+	LIBRARY_STORE_DIR = "pinned-libraries"
+)
+
+// librariesFor works out which libraries a firmware version needs, preferring the source's own manifest
+func librariesFor(fw *firmware.Profile, ver string, srcDir string) ([]firmware.Library, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, LIBRARY_MANIFEST))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fw.LibrariesFor(ver), nil
+		}
+		return nil, err
+	}
+
+	libs := []firmware.Library{}
+	if err := json.Unmarshal(data, &libs); err != nil {
+		return nil, fmt.Errorf("%s: %w", LIBRARY_MANIFEST, err)
+	}
+	return libs, nil
+}
+
+func libraryStoreDir(l firmware.Library) string {
+	return filepath.Join(configuration.Settings.GetString("directories.Data"), LIBRARY_STORE_DIR, l.Name+"@"+l.Version)
+}
+
+// pinLibraries makes sure the exact versions of libs are in the library store, returning their folders
+// for the compiler. arduino-cli only keeps one version of a library installed, so each one is installed
+// the normal way and then copied into the store.
+func pinLibraries(ctx context.Context, instance *rpc.Instance, libs []firmware.Library) ([]string, error) {
+	paths := []string{}
+	for _, l := range libs {
+		dir := libraryStoreDir(l)
+		if err := checkManifest(dir); err != nil {
+			os.RemoveAll(dir)
+			if err := storeLibrary(ctx, instance, l, dir); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", l.Name, l.Version, err)
+			}
+		}
+		paths = append(paths, dir)
+	}
+	return paths, nil
+}
+
+func storeLibrary(ctx context.Context, instance *rpc.Instance, l firmware.Library, dir string) error {
+	// no deps, they'd come in at their newest version. they're in the pinned list themselves.
+	if err := lib.LibraryInstall(ctx, &rpc.LibraryInstallRequest{
+		Instance: instance,
+		Name:     l.Name,
+		Version:  l.Version,
+		NoDeps:   true,
+	}, output.NewNullDownloadProgressCB(), output.NewNullTaskProgressCB()); err != nil {
+		return err
+	}
+
+	list, err := lib.LibraryList(ctx, &rpc.LibraryListRequest{Instance: instance, Name: l.Name})
+	if err != nil {
+		return err
+	}
+	installDir := ""
+	for _, installed := range list.GetInstalledLibraries() {
+		if installed.Library.Name == l.Name && installed.Library.Version == l.Version {
+			installDir = installed.Library.InstallDir
+		}
+	}
+	if installDir == "" {
+		return fmt.Errorf("not found after installing")
+	}
+
+	// same trick as the build workspaces, only a complete copy gets a manifest
+	tmpDir := dir + ".tmp"
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	if err := copyDir(installDir, tmpDir); err != nil {
+		return err
+	}
+	if err := writeManifest(tmpDir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
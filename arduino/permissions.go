@@ -0,0 +1,43 @@
+package arduino
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"go.bug.st/serial"
+)
+
+// PermissionError means we aren't allowed to open a serial port, which on linux usually means
+// the user isn't in the port's group and the udev rule isn't installed
+type PermissionError struct {
+	Port  string
+	Group string
+	Err   error
+}
+
+func (e *PermissionError) Error() string {
+	if e.Group == "" {
+		return fmt.Sprintf("no permission to open %s", e.Port)
+	}
+	return fmt.Sprintf("no permission to open %s: install the udev rule, or run \"sudo usermod -aG %s $USER\" and log back in", e.Port, e.Group)
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// openPort is serial.Open, turning permission problems into a PermissionError
+func openPort(p string, mode *serial.Mode) (serial.Port, error) {
+	port, err := serial.Open(p, mode)
+	if err == nil {
+		return port, nil
+	}
+
+	var portErr *serial.PortError
+	if (errors.As(err, &portErr) && portErr.Code() == serial.PermissionDenied) || errors.Is(err, syscall.EACCES) {
+		group, _ := PortGroup(p)
+		return nil, &PermissionError{Port: p, Group: group, Err: err}
+	}
+	return nil, err
+}
@@ -0,0 +1,27 @@
+package arduino
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// PortGroup is the name of the group that owns a serial device, usually dialout or uucp
+func PortGroup(dev string) (string, error) {
+	info, err := os.Stat(dev)
+	if err != nil {
+		return "", err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("%s: can't read owner", dev)
+	}
+
+	gid := strconv.Itoa(int(st.Gid))
+	if g, err := user.LookupGroupId(gid); err == nil {
+		return g.Name, nil
+	}
+	return gid, nil
+}
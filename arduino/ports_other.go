@@ -0,0 +1,8 @@
+//go:build !linux
+
+package arduino
+
+// PortGroup only means something on linux
+func PortGroup(dev string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package arduino
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/arduino/arduino-cli/commands/core"
+	"github.com/arduino/arduino-cli/configuration"
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+// InstalledCore returns the installed arduino:avr core version, or "" if it isn't installed
+func InstalledCore(instance *rpc.Instance) (string, error) {
+	platforms, err := core.GetPlatforms(&rpc.PlatformListRequest{Instance: instance})
+	if err != nil {
+		return "", err
+	}
+	for _, p := range platforms {
+		if p.Id == "arduino:avr" {
+			return p.Installed, nil
+		}
+	}
+	return "", nil
+}
+
+// ReinstallCore deletes the arduino:avr core and installs it fresh
+func ReinstallCore(ctx context.Context, s *state.State) error {
+	dir := filepath.Join(configuration.Settings.GetString("directories.Data"), "packages", "arduino", "hardware", "avr")
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	s.ReloadInstance()
+	return CheckCore(ctx, s.Instance())
+}
+
+// SelectedLibraries is the pinned library set a custom build of the selected version would be compiled with
+func SelectedLibraries(s *state.State) ([]firmware.Library, error) {
+	fw, ver := s.Firmware(), s.CurrentVersion()
+	return librariesFor(fw, ver, filepath.Join(s.TmpDir, cache.SOURCES_DIR, fw.Name+"_"+ver))
+}
+
+// CheckPinnedLibraries sorts out which of libs haven't been stored yet, and which stored copies have been damaged
+func CheckPinnedLibraries(libs []firmware.Library) (missing []string, damaged []string) {
+	for _, l := range libs {
+		dir := libraryStoreDir(l)
+		if _, err := os.Stat(dir); err != nil {
+			missing = append(missing, l.Name+" "+l.Version)
+		} else if err := checkManifest(dir); err != nil {
+			damaged = append(damaged, l.Name+" "+l.Version)
+		}
+	}
+	return missing, damaged
+}
+
+// RepinLibraries throws away the stored copies of libs and stores them fresh
+func RepinLibraries(ctx context.Context, s *state.State, libs []firmware.Library) error {
+	for _, l := range libs {
+		if err := os.RemoveAll(libraryStoreDir(l)); err != nil {
+			return err
+		}
+	}
+	_, err := pinLibraries(ctx, s.Instance(), libs)
+	return err
+}
@@ -0,0 +1,215 @@
+package arduino
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/reyemxela/LEDControllerUpdater/archive"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/releases"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+	"github.com/reyemxela/LEDControllerUpdater/verify"
+)
+
+const (
+	MANIFEST_FILE = ".manifest.json"
+)
+
+// prepareSource makes sure the firmware source for a release is downloaded and extracted intact,
+// and returns its folder. The folder is shared between builds and never written to.
+func prepareSource(ctx context.Context, s *state.State, fw *firmware.Profile, release *releases.Release, sketchName string) (string, error) {
+	srcDir := filepath.Join(s.TmpDir, cache.SOURCES_DIR, sketchName)
+
+	if _, err := os.Stat(srcDir); err == nil {
+		if err := checkManifest(srcDir); err == nil {
+			return srcDir, nil
+		}
+		// half-extracted or tampered with, start over
+		os.RemoveAll(srcDir)
+	}
+
+	if release.SourceURL == "" {
+		return "", fmt.Errorf("no source available for %s", release.Name)
+	}
+
+	s.SetStatus("Downloading " + release.Name)
+	zipFile := srcDir + ".zip"
+	sum, err := release.Checksum(ctx, path.Base(release.SourceURL))
+	if err != nil {
+		return "", err
+	}
+	if err := verify.Download(ctx, zipFile, release.SourceURL, sum); err != nil {
+		return "", err
+	}
+
+	s.SetStatus("Extracting " + release.Name)
+	extractDir := srcDir + ".extract"
+	os.RemoveAll(extractDir)
+	defer os.RemoveAll(extractDir)
+
+	res, err := archive.Extract(zipFile, extractDir, &archive.Options{
+		Progress: func(done int64, total int64) {
+			if total > 0 {
+				s.SetStatus(fmt.Sprintf("Extracting %s... %d%%", release.Name, done*100/total))
+			}
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	root := res.Root
+
+	// rename .ino file because the arduino tools demand it matches the folder name
+	if err := os.Rename(filepath.Join(root, fw.Sketch), filepath.Join(root, sketchName+".ino")); err != nil {
+		return "", fmt.Errorf("%s: %s not found in source", release.Name, fw.Sketch)
+	}
+
+	// the manifest goes in last, so only a complete extraction ever counts as valid
+	if err := writeManifest(root); err != nil {
+		return "", err
+	}
+	if err := os.Rename(root, srcDir); err != nil {
+		return "", err
+	}
+	return srcDir, nil
+}
+
+// prepareWorkspace sets up a private copy of the source for one build, with the generated layout written in.
+// Workspaces are keyed by a hash of the layout, so different layouts of the same version never share one.
+// It returns the sketch folder and the build output folder.
+func prepareWorkspace(s *state.State, srcDir string, sketchName string, layoutFile string, layoutData []byte) (string, string, error) {
+	key := sketchName + "-" + layoutHash(layoutData)
+	wsDir := filepath.Join(s.TmpDir, cache.BUILDS_DIR, key)
+	sketchDir := filepath.Join(wsDir, sketchName)
+	buildDir := filepath.Join(wsDir, "build")
+
+	if err := checkManifest(sketchDir); err == nil {
+		return sketchDir, buildDir, nil
+	}
+	os.RemoveAll(wsDir)
+
+	tmpDir := wsDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	if err := copyDir(srcDir, filepath.Join(tmpDir, sketchName)); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, sketchName, layoutFile), layoutData, 0666); err != nil {
+		return "", "", err
+	}
+	if err := writeManifest(filepath.Join(tmpDir, sketchName)); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(tmpDir, wsDir); err != nil {
+		return "", "", err
+	}
+	return sketchDir, buildDir, nil
+}
+
+func layoutHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// hashTree lists the sha256 of every file under dir (besides the manifest itself), by slash separated relative path
+func hashTree(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == MANIFEST_FILE {
+			return nil
+		}
+		sum, err := verify.FileSHA256(p)
+		if err != nil {
+			return err
+		}
+		sums[rel] = sum
+		return nil
+	})
+	return sums, err
+}
+
+func writeManifest(dir string) error {
+	sums, err := hashTree(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sums, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MANIFEST_FILE), data, 0666)
+}
+
+// checkManifest makes sure dir holds exactly the files its manifest says, unchanged
+func checkManifest(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+	want := make(map[string]string)
+	if err := json.Unmarshal(data, &want); err != nil {
+		return err
+	}
+
+	got, err := hashTree(dir)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("%s: files added or missing", dir)
+	}
+	for name, sum := range want {
+		if got[name] != sum {
+			return fmt.Errorf("%s: %s changed", dir, name)
+		}
+	}
+	return nil
+}
+
+func copyDir(src string, dest string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if filepath.ToSlash(rel) == MANIFEST_FILE {
+			return nil
+		}
+		target := filepath.Join(dest, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0777)
+		case d.Type().IsRegular():
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, 0666)
+		}
+		return nil
+	})
+}
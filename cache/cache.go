@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// folders inside the app's temp dir, each holding one kind of cached data
+const (
+	API_DIR       = "api"
+	SOURCES_DIR   = "sources"
+	BUILDS_DIR    = "builds"
+	HEX_CACHE_DIR = "hexcache"
+)
+
+const (
+	DEFAULT_MAX_AGE  = 30 * 24 * time.Hour
+	DEFAULT_MAX_SIZE = 500 << 20
+)
+
+// Category is one kind of cached data
+type Category string
+
+const (
+	CATEGORY_API       Category = "API responses"
+	CATEGORY_SOURCES   Category = "Firmware sources"
+	CATEGORY_BUILDS    Category = "Build folders"
+	CATEGORY_HEXES     Category = "Compiled builds"
+	CATEGORY_DRIVERS   Category = "Drivers"
+	CATEGORY_BACKUPS   Category = "Old app versions"
+	CATEGORY_DOWNLOADS Category = "Downloads"
+)
+
+var CATEGORIES = []Category{
+	CATEGORY_DOWNLOADS, CATEGORY_SOURCES, CATEGORY_BUILDS, CATEGORY_HEXES, CATEGORY_API, CATEGORY_DRIVERS, CATEGORY_BACKUPS,
+}
+
+// Usage is how much space a category takes up
+type Usage struct {
+	Category Category
+	Size     int64
+	Entries  int
+}
+
+// entry is the smallest thing that gets pruned: a top level file, or one item in a category folder
+// (a source tree, a build workspace, a hex...)
+type entry struct {
+	path     string
+	category Category
+	size     int64
+	modTime  time.Time // newest change anywhere inside
+}
+
+func classify(name string, isDir bool) Category {
+	switch {
+	case isDir && name == API_DIR:
+		return CATEGORY_API
+	case isDir && name == SOURCES_DIR:
+		return CATEGORY_SOURCES
+	case isDir && name == BUILDS_DIR:
+		return CATEGORY_BUILDS
+	case isDir && name == HEX_CACHE_DIR:
+		return CATEGORY_HEXES
+	case strings.HasSuffix(name, ".bak"):
+		return CATEGORY_BACKUPS
+	case strings.HasPrefix(strings.ToLower(name), "ch34"):
+		return CATEGORY_DRIVERS
+	}
+	return CATEGORY_DOWNLOADS
+}
+
+func scan(dir string) ([]entry, error) {
+	top, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := []entry{}
+	for _, t := range top {
+		p := filepath.Join(dir, t.Name())
+		c := classify(t.Name(), t.IsDir())
+
+		if !t.IsDir() || c == CATEGORY_DOWNLOADS {
+			entries = append(entries, measure(p, c))
+			continue
+		}
+
+		children, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			entries = append(entries, measure(filepath.Join(p, child.Name()), c))
+		}
+	}
+	return entries, nil
+}
+
+func measure(p string, c Category) entry {
+	e := entry{path: p, category: c}
+	filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			e.size += info.Size()
+		}
+		if info.ModTime().After(e.modTime) {
+			e.modTime = info.ModTime()
+		}
+		return nil
+	})
+	return e
+}
+
+// GetUsage reports the space used by each category under dir, in CATEGORIES order
+func GetUsage(dir string) ([]Usage, error) {
+	entries, err := scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[Category]*Usage)
+	for _, c := range CATEGORIES {
+		byCategory[c] = &Usage{Category: c}
+	}
+	for _, e := range entries {
+		u := byCategory[e.category]
+		u.Size += e.size
+		u.Entries++
+	}
+
+	usage := make([]Usage, len(CATEGORIES))
+	for i, c := range CATEGORIES {
+		usage[i] = *byCategory[c]
+	}
+	return usage, nil
+}
+
+// Prune removes anything not touched in maxAge, then the oldest entries until everything fits in maxSize.
+// A zero maxAge or maxSize skips that check. It returns how many bytes were freed.
+func Prune(dir string, maxAge time.Duration, maxSize int64) (int64, error) {
+	entries, err := scan(dir)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	var freed int64
+	for _, e := range entries {
+		tooOld := maxAge > 0 && time.Since(e.modTime) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return freed, err
+		}
+		total -= e.size
+		freed += e.size
+	}
+	return freed, nil
+}
+
+// Clear removes everything in one category, returning how many bytes were freed
+func Clear(dir string, c Category) (int64, error) {
+	entries, err := scan(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var freed int64
+	for _, e := range entries {
+		if e.category != c {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return freed, err
+		}
+		freed += e.size
+	}
+	return freed, nil
+}
+
+// FormatSize turns a byte count into something readable, "12.3 MB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
@@ -1,18 +1,102 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/reyemxela/LEDControllerUpdater/arduino"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
 	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/config"
+	"github.com/reyemxela/LEDControllerUpdater/doctor"
+	"github.com/reyemxela/LEDControllerUpdater/releases"
 	"github.com/reyemxela/LEDControllerUpdater/state"
+	"github.com/reyemxela/LEDControllerUpdater/udev"
 	"github.com/reyemxela/LEDControllerUpdater/update"
-	"github.com/reyemxela/LEDControllerUpdater/utils"
 	"github.com/rivo/tview"
 )
 
 func main() {
+	exportBundle := flag.String("export-bundle", "", "export the installed arduino core and libraries to a bundle `file`, then exit")
+	importBundle := flag.String("import-bundle", "", "import an arduino core and libraries bundle `file`, then exit")
+	source := flag.String("source", "", "firmware release `source` for this run, e.g. github:owner/repo, gitea:https://host/owner/repo, gitlab:https://host/group/project or index:/path/to/folder")
+	channel := flag.String("channel", "", "switch to the `stable`, beta or all release channel and remember it")
+	arduinoDir := flag.String("arduino-dir", "", "keep arduino cores and libraries in `dir` for this run")
+	hidePorts := flag.Bool("hide-other-ports", false, "hide bluetooth, built in and other non usb-serial ports, and remember it (-hide-other-ports=false to show them again)")
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *arduinoDir != "" {
+		// relative to where we're run from, not the config dir
+		dir, err := filepath.Abs(*arduinoDir)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		config.Override(func(c *config.Config) {
+			c.ArduinoDir = dir
+		})
+	}
+
+	switch flag.Arg(0) {
+	case "clean":
+		runClean(flag.Args()[1:])
+		return
+	case "doctor":
+		runDoctor(flag.Args()[1:])
+		return
+	case "udev-rules":
+		runUdevRules(flag.Args()[1:])
+		return
+	}
+
+	if *version {
+		fmt.Println(state.APP_VERSION)
+		return
+	}
+
+	if *channel != "" {
+		err := config.Load()
+		if err == nil {
+			err = config.Update(func(c *config.Config) {
+				c.Channel = string(releases.ParseChannel(*channel))
+			})
+		}
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "hide-other-ports" {
+			return
+		}
+		err := config.Load()
+		if err == nil {
+			err = config.Update(func(c *config.Config) {
+				c.HideOtherPorts = *hidePorts
+			})
+		}
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	})
+
+	if *source != "" {
+		config.Override(func(c *config.Config) {
+			c.SourceOverride = *source
+		})
+	}
+
+	if *exportBundle != "" || *importBundle != "" {
+		runBundle(*exportBundle, *importBundle)
+		return
+	}
+
 	ui := &UI{}
 	s, err := state.NewState("CLI", ui.setStatus)
 	if err != nil {
@@ -25,30 +109,32 @@ func main() {
 
 	mainWindow := createMainWindow(ui)
 
-	go arduino.WatchPorts(ui.state, func() {
-		ui.app.QueueUpdateDraw(func() {
-			ui.clearPortList()
-
-			if len(ui.state.Ports) < 1 {
-				ui.portList.AddOption(" -No Ports- ", nil)
-				ui.portList.SetCurrentOption(0)
-				return
-			}
-
-			portNames := utils.ListKeys(ui.state.Ports)
-			selected := 0
-			for i, p := range portNames {
-				ui.portList.AddOption(p, nil)
-				if p == ui.state.CurrentPort {
-					selected = i
-				}
-			}
-			ui.portList.SetCurrentOption(selected)
-		})
+	ui.state.Subscribe(func(c state.Change) {
+		switch c {
+		case state.PortsChanged:
+			ui.app.QueueUpdateDraw(func() {
+				ui.setPorts()
+				ui.setNotes()
+			})
+		case state.SelectionChanged:
+			ui.app.QueueUpdateDraw(ui.setNotes)
+		case state.VersionsChanged:
+			ui.app.QueueUpdateDraw(ui.setVersions)
+		case state.FirmwareChanged:
+			ui.app.QueueUpdateDraw(ui.setFirmware)
+		case state.BoardChanged:
+			ui.app.QueueUpdateDraw(func() {
+				ui.setBoard()
+				ui.setLayouts()
+			})
+		}
 	})
 
+	go arduino.WatchPorts(ui.state)
+
 	go func() {
-		common.Init(ui.state, ui.setVersions)
+		common.Init(ui.state)
+		update.CleanOldVersions(ui.state)
 
 		time.Sleep(1 * time.Second)
 
@@ -69,3 +155,145 @@ func main() {
 		panic(err)
 	}
 }
+
+// runBundle handles the offline bundle flags without starting the interactive UI
+func runBundle(exportFile string, importFile string) {
+	s, err := state.NewState("CLI", func(text string) { fmt.Println(text) })
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if importFile != "" {
+		err = common.ImportBundle(s, importFile)
+	} else {
+		err = arduino.ExportBundle(s, exportFile)
+	}
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Done!")
+}
+
+// runClean handles "clean": shows what's cached, then prunes or clears it
+func runClean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	maxAge := fs.Duration("max-age", cache.DEFAULT_MAX_AGE, "remove anything not used in this long")
+	maxSize := fs.Int64("max-size", cache.DEFAULT_MAX_SIZE>>20, "then remove the oldest files until the cache fits in this many `MB`")
+	all := fs.Bool("all", false, "remove everything")
+	dryRun := fs.Bool("n", false, "only show disk usage")
+	fs.Parse(args)
+
+	s, err := state.NewState("CLI", func(text string) {})
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	printUsage := func() {
+		usage, err := cache.GetUsage(s.TmpDir)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+		var total int64
+		for _, u := range usage {
+			fmt.Printf("  %-18s %10s  (%d)\n", u.Category, cache.FormatSize(u.Size), u.Entries)
+			total += u.Size
+		}
+		fmt.Printf("  %-18s %10s\n", "Total", cache.FormatSize(total))
+	}
+
+	fmt.Println(s.TmpDir)
+	printUsage()
+	if *dryRun {
+		return
+	}
+
+	var freed int64
+	if *all {
+		for _, c := range cache.CATEGORIES {
+			n, err := common.ClearCache(s, c)
+			freed += n
+			if err != nil {
+				fmt.Println("Error: " + err.Error())
+				os.Exit(1)
+			}
+		}
+	} else {
+		freed, err = common.PruneCache(s, *maxAge, *maxSize<<20)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("\nFreed %s\n", cache.FormatSize(freed))
+	printUsage()
+}
+
+// runDoctor handles "doctor": runs the health checks, and repairs what it can with -fix
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "try to repair failed checks")
+	fs.Parse(args)
+
+	s, err := state.NewState("CLI", func(text string) {})
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	ctx := context.Background()
+
+	printResults := func(results []doctor.Result) {
+		for _, r := range results {
+			fmt.Printf("  [%-4s] %-26s %s\n", r.Status, r.Name, r.Detail)
+		}
+	}
+
+	results := doctor.Run(ctx, s)
+	printResults(results)
+
+	repairable := doctor.Repairable(results)
+	if len(repairable) == 0 {
+		return
+	}
+	if !*fix {
+		fmt.Println("\nRun \"doctor -fix\" to repair these automatically")
+		return
+	}
+
+	s.StatusFunc = func(text string) { fmt.Println("  " + text) }
+	for _, r := range repairable {
+		fmt.Printf("\nRepairing %s...\n", r.Name)
+		if err := r.Repair(ctx); err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	}
+
+	fmt.Println()
+	printResults(doctor.Run(ctx, s))
+}
+
+// runUdevRules handles "udev-rules": prints the rule file, or installs it with -install
+func runUdevRules(args []string) {
+	fs := flag.NewFlagSet("udev-rules", flag.ExitOnError)
+	install := fs.Bool("install", false, "install the rules to "+udev.RULES_FILE+" (asks for root)")
+	fs.Parse(args)
+
+	if !*install {
+		fmt.Print(udev.Rules(udev.DEVICES))
+		return
+	}
+
+	s, err := state.NewState("CLI", func(text string) { fmt.Println(text) })
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if err := common.InstallUdevRule(s); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"strconv"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/reyemxela/LEDControllerUpdater/arduino"
 	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/layout"
 	"github.com/reyemxela/LEDControllerUpdater/state"
 	"github.com/reyemxela/LEDControllerUpdater/utils"
 	"github.com/rivo/tview"
@@ -16,6 +17,7 @@ const (
 	QUIT_TEXT   = "quit"
 	UPDATE_TEXT = "Update Available!"
 	CH340_TEXT  = "CH340 drivers"
+	UDEV_TEXT   = "udev rule"
 	SEPARATOR   = "------"
 )
 
@@ -23,17 +25,23 @@ type UI struct {
 	app   *tview.Application
 	state *state.State
 
+	firmwareList *tview.DropDown
+	boardList    *tview.DropDown
 	verSelect    *tview.List
 	layoutSelect *tview.List
+	notesView    *tview.TextView
 
 	ledForm      *tview.Form
 	checkboxForm *tview.Form
 
-	customSection *tview.Pages
-	flashSection  *tview.Flex
+	firmwareSection *tview.Flex
+	customSection   *tview.Pages
+	flashSection    *tview.Flex
 
-	flashButton *tview.Button
-	portList    *tview.DropDown
+	flashButton  *tview.Button
+	cancelButton *tview.Button
+	portList     *tview.DropDown
+	ports        common.PortList
 
 	statusBar *tview.TextView
 
@@ -49,19 +57,27 @@ func (ui *UI) setStatus(text string) {
 
 func createFlows(ui *UI) {
 	ui.flowWithCustom = []tview.Primitive{
+		ui.firmwareList,
+		ui.boardList,
 		ui.verSelect,
 		ui.layoutSelect,
+		ui.notesView,
 		ui.ledForm,
 		ui.checkboxForm,
 		ui.portList,
 		ui.flashButton,
+		ui.cancelButton,
 	}
 
 	ui.flowWithoutCustom = []tview.Primitive{
+		ui.firmwareList,
+		ui.boardList,
 		ui.verSelect,
 		ui.layoutSelect,
+		ui.notesView,
 		ui.portList,
 		ui.flashButton,
+		ui.cancelButton,
 	}
 }
 
@@ -70,16 +86,40 @@ func createVerSelect(ui *UI) {
 	ui.verSelect.SetBorder(true).SetTitle("Version")
 	ui.verSelect.SetChangedFunc(func(i int, text, _ string, _ rune) {
 		ui.layoutSelect.Clear()
-		if text == QUIT_TEXT || text == UPDATE_TEXT || text == CH340_TEXT || text == SEPARATOR {
+		if text == QUIT_TEXT || text == UPDATE_TEXT || text == CH340_TEXT || text == UDEV_TEXT || text == SEPARATOR {
 			return
 		}
 
-		for _, l := range utils.ListKeys(ui.state.Versions[text]) {
-			ui.layoutSelect.AddItem(l, "", 0, nil)
-		}
-		ui.layoutSelect.AddItem("-Custom-", "", 0, nil)
-		ui.state.CurrentVersion = text
+		ui.state.SetCurrentVersion(text)
+		ui.setLayouts()
+	})
+}
+
+func createFirmwareSection(ui *UI) {
+	ui.firmwareList = tview.NewDropDown().SetLabel("Firmware: ")
+	for _, name := range firmware.Names() {
+		ui.firmwareList.AddOption(name, nil)
+	}
+	ui.firmwareList.SetSelectedFunc(func(text string, index int) {
+		common.SetFirmware(ui.state, text)
+	})
+
+	ui.boardList = tview.NewDropDown().SetLabel("Board: ")
+	ui.boardList.SetSelectedFunc(func(text string, index int) {
+		ui.state.SetBoard(text)
 	})
+
+	ui.firmwareSection = tview.NewFlex().
+		AddItem(ui.firmwareList, 0, 2, false).
+		AddItem(ui.boardList, 0, 1, false)
+	ui.firmwareSection.SetBorder(true)
+
+	ui.setFirmware()
+}
+
+func createNotesView(ui *UI) {
+	ui.notesView = tview.NewTextView().SetWordWrap(true).SetScrollable(true)
+	ui.notesView.SetBorder(true).SetTitle("Release notes")
 }
 
 func createLayoutSelect(ui *UI) {
@@ -87,15 +127,15 @@ func createLayoutSelect(ui *UI) {
 	ui.layoutSelect.SetBorder(true).SetTitle("Layout")
 
 	ui.layoutSelect.SetChangedFunc(func(i int, text, _ string, _ rune) {
-		ui.state.CurrentLayout = text
+		ui.state.SetCurrentLayout(text)
 		if text == "-Custom-" {
 			ui.customSection.SwitchToPage("Custom")
 			ui.customEnabled = true
-			ui.state.CustomSelected = true
+			ui.state.SetCustomSelected(true)
 		} else {
 			ui.customSection.SwitchToPage("Blank")
 			ui.customEnabled = false
-			ui.state.CustomSelected = false
+			ui.state.SetCustomSelected(false)
 		}
 	})
 }
@@ -111,40 +151,42 @@ func createLedForm(ui *UI) {
 		return true
 	}
 
+	custom := ui.state.CustomLayout()
 	ui.ledForm = tview.NewForm().
-		AddInputField("Wing LEDs:", strconv.Itoa(ui.state.CustomLayout.WingLEDs), 4, layoutValidate, func(text string) {
-			ui.state.CustomLayout.WingLEDs, _ = strconv.Atoi("0" + text)
+		AddInputField("Wing LEDs:", strconv.Itoa(custom.WingLEDs), 4, layoutValidate, func(text string) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.WingLEDs, _ = strconv.Atoi("0" + text) })
 		}).
-		AddInputField("Nose LEDs:", strconv.Itoa(ui.state.CustomLayout.NoseLEDs), 4, layoutValidate, func(text string) {
-			ui.state.CustomLayout.NoseLEDs, _ = strconv.Atoi("0" + text)
+		AddInputField("Nose LEDs:", strconv.Itoa(custom.NoseLEDs), 4, layoutValidate, func(text string) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.NoseLEDs, _ = strconv.Atoi("0" + text) })
 		}).
-		AddInputField("Fuse LEDs:", strconv.Itoa(ui.state.CustomLayout.FuseLEDs), 4, layoutValidate, func(text string) {
-			ui.state.CustomLayout.FuseLEDs, _ = strconv.Atoi("0" + text)
+		AddInputField("Fuse LEDs:", strconv.Itoa(custom.FuseLEDs), 4, layoutValidate, func(text string) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.FuseLEDs, _ = strconv.Atoi("0" + text) })
 		}).
-		AddInputField("Tail LEDs:", strconv.Itoa(ui.state.CustomLayout.TailLEDs), 4, layoutValidate, func(text string) {
-			ui.state.CustomLayout.TailLEDs, _ = strconv.Atoi("0" + text)
+		AddInputField("Tail LEDs:", strconv.Itoa(custom.TailLEDs), 4, layoutValidate, func(text string) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.TailLEDs, _ = strconv.Atoi("0" + text) })
 		}).
-		AddInputField("Nav LEDs:", strconv.Itoa(ui.state.CustomLayout.WingNavLEDs), 4, layoutValidate, func(text string) {
-			ui.state.CustomLayout.WingNavLEDs, _ = strconv.Atoi("0" + text)
+		AddInputField("Nav LEDs:", strconv.Itoa(custom.WingNavLEDs), 4, layoutValidate, func(text string) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.WingNavLEDs, _ = strconv.Atoi("0" + text) })
 		})
 }
 
 func createCheckboxForm(ui *UI) {
+	custom := ui.state.CustomLayout()
 	ui.checkboxForm = tview.NewForm().
-		AddCheckbox("Reverse:", ui.state.CustomLayout.WingRev, func(checked bool) {
-			ui.state.CustomLayout.WingRev = checked
+		AddCheckbox("Reverse:", custom.WingRev, func(checked bool) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.WingRev = checked })
 		}).
-		AddCheckbox("Reverse:", ui.state.CustomLayout.NoseRev, func(checked bool) {
-			ui.state.CustomLayout.NoseRev = checked
+		AddCheckbox("Reverse:", custom.NoseRev, func(checked bool) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.NoseRev = checked })
 		}).
-		AddCheckbox("Reverse:", ui.state.CustomLayout.FuseRev, func(checked bool) {
-			ui.state.CustomLayout.FuseRev = checked
+		AddCheckbox("Reverse:", custom.FuseRev, func(checked bool) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.FuseRev = checked })
 		}).
-		AddCheckbox("Reverse:", ui.state.CustomLayout.TailRev, func(checked bool) {
-			ui.state.CustomLayout.TailRev = checked
+		AddCheckbox("Reverse:", custom.TailRev, func(checked bool) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.TailRev = checked })
 		}).
-		AddCheckbox("Nose/Fuse join:", ui.state.CustomLayout.NoseFuseJoin, func(checked bool) {
-			ui.state.CustomLayout.NoseFuseJoin = checked
+		AddCheckbox("Nose/Fuse join:", custom.NoseFuseJoin, func(checked bool) {
+			ui.state.UpdateCustomLayout(func(l *layout.CustomLayout) { l.NoseFuseJoin = checked })
 		})
 }
 
@@ -154,33 +196,24 @@ func createFlashSection(ui *UI) {
 		SetCurrentOption(0).
 		SetLabel("Port: ").SetTextOptions("", "", "", "", " -None-")
 	ui.portList.SetSelectedFunc(func(text string, index int) {
-		ui.state.CurrentPort = text
-		if text == " -No Ports- " {
-			ui.state.Ready.PortSelected = false
-		} else {
-			ui.state.Ready.PortSelected = true
-		}
+		ui.state.SetCurrentPort(ui.ports.Address(index))
 	})
 
 	ui.flashButton = tview.NewButton("Flash")
 	ui.flashButton.SetSelectedFunc(func() {
-		if ui.state.CheckReady() {
-			go func() {
-				ui.state.Ready.NotFlashing = false
-				err := arduino.DoFlash(ui.state)
-				if err != nil {
-					ui.state.SetStatus(err.Error())
-				} else {
-					ui.state.SetStatus("Done!")
-				}
-				ui.state.Ready.NotFlashing = true
-			}()
-		}
+		common.Flash(ui.state)
+	})
+
+	ui.cancelButton = tview.NewButton("Cancel")
+	ui.cancelButton.SetSelectedFunc(func() {
+		ui.state.CancelOperation()
 	})
 
 	ui.flashSection = tview.NewFlex().
 		AddItem(ui.portList, 0, 2, false).
-		AddItem(ui.flashButton, 9, 0, false)
+		AddItem(ui.flashButton, 9, 0, false).
+		AddItem(tview.NewBox(), 1, 0, false).
+		AddItem(ui.cancelButton, 10, 0, false)
 	ui.flashSection.SetBorder(true)
 }
 
@@ -196,8 +229,10 @@ func createCustomSection(ui *UI) {
 }
 
 func createMainWindow(ui *UI) *tview.Flex {
+	createFirmwareSection(ui)
 	createVerSelect(ui)
 	createLayoutSelect(ui)
+	createNotesView(ui)
 	createLedForm(ui)
 	createCheckboxForm(ui)
 	createFlashSection(ui)
@@ -215,6 +250,8 @@ func createMainWindow(ui *UI) *tview.Flex {
 				AddItem(ui.layoutSelect, 0, 1, false).
 				AddItem(
 					tview.NewFlex().
+						AddItem(ui.firmwareSection, 3, 0, false).
+						AddItem(ui.notesView, 0, 1, false).
 						AddItem(ui.customSection, 0, 1, false).
 						AddItem(ui.flashSection, 5, 0, false).
 						SetDirection(tview.FlexRow),
@@ -279,7 +316,7 @@ func (ui *UI) clearPortList() {
 
 func (ui *UI) setVersions() {
 	ui.verSelect.Clear()
-	for _, v := range utils.ListKeys(ui.state.Versions) {
+	for _, v := range utils.ListVersions(ui.state.Versions()) {
 		ui.verSelect.AddItem(v, "", 0, nil)
 	}
 	ui.verSelect.AddItem(SEPARATOR, "", 0, nil)
@@ -288,7 +325,84 @@ func (ui *UI) setVersions() {
 			go common.InstallCH340(ui.state)
 		})
 	}
+	if runtime.GOOS == "linux" {
+		ui.verSelect.AddItem(UDEV_TEXT, "", 0, func() {
+			// give the terminal back while sudo asks for a password
+			var err error
+			ui.app.Suspend(func() {
+				err = common.InstallUdevRule(ui.state)
+			})
+			if err != nil {
+				ui.state.SetStatus("Error: " + err.Error())
+			}
+		})
+	}
 	ui.verSelect.AddItem("quit", "", 'q', func() {
 		ui.app.Stop()
 	})
 }
+
+func (ui *UI) setLayouts() {
+	ui.layoutSelect.Clear()
+	if ui.state.Release() == nil {
+		return
+	}
+
+	prev := ui.state.CurrentLayout()
+	options := utils.ListVersions(ui.state.Layouts())
+	if ui.state.Firmware().Schema != nil {
+		options = append(options, "-Custom-")
+	}
+	for _, l := range options {
+		ui.layoutSelect.AddItem(l, "", 0, nil)
+	}
+	ui.layoutSelect.SetCurrentItem(common.LayoutIndex(ui.state, options, prev))
+}
+
+// setNotes shows the selected version's release notes, markdown and all, since tview can't render it
+func (ui *UI) setNotes() {
+	ui.notesView.SetText(common.ReleaseNotes(ui.state)).ScrollToBeginning()
+}
+
+func (ui *UI) setFirmware() {
+	fw := ui.state.Firmware()
+	for i, name := range firmware.Names() {
+		if name == fw.Name {
+			ui.firmwareList.SetCurrentOption(i)
+		}
+	}
+
+	for ui.boardList.GetOptionCount() > 0 {
+		ui.boardList.RemoveOption(0)
+	}
+	for _, name := range fw.BoardNames() {
+		ui.boardList.AddOption(name, nil)
+	}
+	ui.setBoard()
+}
+
+func (ui *UI) setBoard() {
+	board := ui.state.Board()
+	for i, name := range ui.state.Firmware().BoardNames() {
+		if name == board.Name {
+			ui.boardList.SetCurrentOption(i)
+		}
+	}
+}
+
+func (ui *UI) setPorts() {
+	ui.clearPortList()
+
+	labels, selected := ui.ports.Update(ui.state)
+	if len(labels) < 1 {
+		ui.portList.AddOption(" -No Ports- ", nil)
+		ui.portList.SetCurrentOption(0)
+		return
+	}
+
+	// nothing selected stays that way, rather than the list picking the first port
+	for _, label := range labels {
+		ui.portList.AddOption(label, nil)
+	}
+	ui.portList.SetCurrentOption(selected)
+}
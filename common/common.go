@@ -1,15 +1,25 @@
 package common
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/archive"
 	"github.com/reyemxela/LEDControllerUpdater/arduino"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/config"
+	"github.com/reyemxela/LEDControllerUpdater/ports"
 	"github.com/reyemxela/LEDControllerUpdater/releases"
 	"github.com/reyemxela/LEDControllerUpdater/state"
+	"github.com/reyemxela/LEDControllerUpdater/udev"
 	"github.com/reyemxela/LEDControllerUpdater/utils"
 )
 
@@ -38,7 +48,7 @@ func InstallCH340(s *state.State) {
 			}
 		}
 
-		_, err = utils.UnzipFile(zipFile, s.TmpDir)
+		_, err = archive.Extract(zipFile, s.TmpDir, nil)
 		if err != nil {
 			s.SetStatus(err.Error())
 			return
@@ -56,30 +66,304 @@ func InstallCH340(s *state.State) {
 	s.SetStatus("Started CH340 installer")
 }
 
-func Init(s *state.State, setVersions func()) {
+// InstallUdevRule gives everyone access to the usb serial chips the controllers use, so flashing
+// doesn't need the dialout group. It asks for root through pkexec, or sudo if that's all there is.
+func InstallUdevRule(s *state.State) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	s.SetStatus("Installing udev rule")
+
+	sudo := "pkexec"
+	if _, err := exec.LookPath(sudo); err != nil {
+		sudo = "sudo"
+	}
+	// the rules go in on stdin, sudo asks for its password on the terminal itself
+	cmd := exec.Command(sudo, "sh", "-c", udev.InstallScript())
+	cmd.Stdin = strings.NewReader(udev.Rules(udev.DEVICES))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("couldn't install udev rule: %w", err)
+	}
+
+	s.SetStatus("Installed udev rule, unplug the controller and plug it back in")
+	return nil
+}
+
+func Init(s *state.State) {
+	// keep the temp dir from growing forever. before anything can be flashed, so nothing's waiting on it.
+	PruneCache(s, cache.DEFAULT_MAX_AGE, cache.DEFAULT_MAX_SIZE)
+
+	LoadVersions(s)
+
+	CheckArduino(s)
+
+	s.SetStatus("Ready")
+}
+
+// LoadVersions fetches the selected firmware's versions from its release source
+func LoadVersions(s *state.State) {
 	s.SetStatus("Downloading versions...")
-	v, err := releases.GetVersions()
+	fw := s.Firmware()
+
+	c := config.Get()
+	v, err := fw.GetVersions(context.Background(), c.SourceFor(fw.Name), releases.ParseChannel(c.Channel))
+	if err != nil {
+		s.SetStatus("Error: " + err.Error())
+	}
+	// don't clobber the versions if the firmware got switched while we were downloading
+	if s.Firmware() == fw {
+		s.SetVersions(v)
+	}
+}
+
+// SetFirmware switches firmware profiles, remembers the choice, and loads the new firmware's versions
+func SetFirmware(s *state.State, name string) {
+	if s.Firmware().Name == name {
+		return
+	}
+	s.SetFirmware(name)
+
+	err := config.Update(func(c *config.Config) {
+		c.Firmware = name
+	})
+	if err != nil {
+		s.SetStatus("Error: " + err.Error())
+	}
+
+	go func() {
+		LoadVersions(s)
+		s.SetStatus("Ready")
+	}()
+}
+
+// SetChannel switches release channels, remembers the choice, and reloads versions to match
+func SetChannel(s *state.State, channel releases.Channel) {
+	err := config.Update(func(c *config.Config) {
+		c.Channel = string(channel)
+	})
+	if err != nil {
+		s.SetStatus("Error: " + err.Error())
+	}
+
+	go func() {
+		LoadVersions(s)
+		s.SetStatus("Ready")
+	}()
+}
+
+// VisiblePorts lists the ports to show, likely controllers first, leaving out non usb-serial ones
+// if the user asked to hide them. The current port is always there.
+func VisiblePorts(s *state.State) []*ports.Info {
+	hide := config.Get().HideOtherPorts
+	current := s.CurrentPort()
+
+	o := []*ports.Info{}
+	for _, p := range ports.Sorted(s.Ports()) {
+		if hide && p.Kind == ports.KIND_OTHER && p.Address != current {
+			continue
+		}
+		o = append(o, p)
+	}
+	return o
+}
+
+// PortList is a frontend's copy of the visible ports. The port watcher redraws it on its own goroutine
+// while the UI turns selections back into addresses on another, so it's locked.
+type PortList struct {
+	mu     sync.Mutex
+	labels []string
+	addrs  []string
+}
+
+// Update reloads the visible ports, returning their labels and the index of the current port, -1 if it isn't shown
+func (l *PortList) Update(s *state.State) ([]string, int) {
+	current := s.CurrentPort()
+	labels := []string{}
+	addrs := []string{}
+	selected := -1
+	for i, p := range VisiblePorts(s) {
+		labels = append(labels, p.Label())
+		addrs = append(addrs, p.Address)
+		if p.Address == current {
+			selected = i
+		}
+	}
+
+	l.mu.Lock()
+	l.labels, l.addrs = labels, addrs
+	l.mu.Unlock()
+	return append([]string{}, labels...), selected
+}
+
+// Address is the address of the port at index i, or "" if there's no such port
+func (l *PortList) Address(i int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i < 0 || i >= len(l.addrs) {
+		return ""
+	}
+	return l.addrs[i]
+}
+
+// AddressOf is the address of the port shown as label, or "" if there's no such port
+func (l *PortList) AddressOf(label string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, lab := range l.labels {
+		if lab == label {
+			return l.addrs[i]
+		}
+	}
+	return ""
+}
+
+// SetHideOtherPorts remembers whether to hide non usb-serial ports, and redraws the port lists
+func SetHideOtherPorts(s *state.State, hide bool) {
+	err := config.Update(func(c *config.Config) {
+		c.HideOtherPorts = hide
+	})
 	if err != nil {
 		s.SetStatus("Error: " + err.Error())
 	}
-	s.Versions = v
-	setVersions()
+	s.RefreshPorts()
+}
 
+// CheckArduino makes sure the arduino core and libraries are installed, updating Ready to match
+func CheckArduino(s *state.State) {
 	s.SetStatus("Checking arduino core...")
-	err = arduino.CheckCore(s.Instance)
+	err := arduino.CheckCore(context.Background(), s.Instance())
 	if err != nil {
 		s.SetStatus("Error: " + err.Error())
-	} else {
-		s.Ready.CoreInstalled = true
 	}
+	s.UpdateReady(func(r *state.Ready) {
+		r.CoreInstalled = err == nil
+	})
 
 	s.SetStatus("Checking arduino libraries...")
-	err = arduino.CheckLibraries(s.Instance)
+	err = arduino.CheckLibraries(context.Background(), s.Instance(), s.Firmware().LibrariesFor(""))
 	if err != nil {
 		s.SetStatus("Error: " + err.Error())
-	} else {
-		s.Ready.LibrariesInstalled = true
 	}
+	s.UpdateReady(func(r *state.Ready) {
+		r.LibrariesInstalled = err == nil
+	})
+}
 
-	s.SetStatus("Ready")
+// ImportBundle installs an offline arduino bundle and re-checks the core/libraries against it
+func ImportBundle(s *state.State, filename string) error {
+	err := arduino.ImportBundle(s, filename)
+	if err != nil {
+		return err
+	}
+
+	CheckArduino(s)
+	if r := s.Ready(); !r.CoreInstalled || !r.LibrariesInstalled {
+		return fmt.Errorf("bundle is missing the arduino core or libraries")
+	}
+	s.SetStatus("Bundle imported")
+	return nil
+}
+
+// Flash starts flashing the current selection in the background, as a cancellable operation.
+// Nothing happens if we're not ready or something is already being flashed.
+func Flash(s *state.State) {
+	if !s.CheckReady() || !s.StartFlashing() {
+		return
+	}
+	ctx := s.NewOperation()
+	port := s.Port()
+	ver := s.CurrentVersion()
+	s.SetBuildSize(nil)
+
+	go func() {
+		defer s.FinishFlashing()
+		defer s.CancelOperation()
+
+		err := arduino.DoFlash(ctx, s)
+		switch {
+		case ctx.Err() != nil:
+			s.SetStatus("Cancelled")
+		case err != nil:
+			s.SetStatus(err.Error())
+		default:
+			rememberFlashed(s, port, ver)
+			if size := s.BuildSize(); size != nil {
+				s.SetStatus("Done! " + size.String())
+			} else {
+				s.SetStatus("Done!")
+			}
+		}
+	}()
+}
+
+// flashedKey identifies a board by its usb serial number, or "" if it doesn't have one.
+// The port alone isn't enough, a different board plugged into the same port would get the wrong version.
+func flashedKey(s *state.State, port *rpc.Port) string {
+	if port == nil || port.Properties["serialNumber"] == "" {
+		return ""
+	}
+	return s.Firmware().Name + "|" + port.Properties["serialNumber"]
+}
+
+// FlashedVersion is the firmware version we last flashed to the board on the current port, or "" if we don't know
+func FlashedVersion(s *state.State) string {
+	key := flashedKey(s, s.Port())
+	if key == "" {
+		return ""
+	}
+	return config.Get().Flashed[key]
+}
+
+func rememberFlashed(s *state.State, port *rpc.Port, ver string) {
+	key := flashedKey(s, port)
+	if key == "" || ver == "" {
+		return
+	}
+	config.Update(func(c *config.Config) {
+		c.Flashed[key] = ver
+	})
+}
+
+// ReleaseNotes is the markdown notes for the selected version, covering everything since the
+// version on the board when we know it
+func ReleaseNotes(s *state.State) string {
+	return s.Versions().NotesSince(FlashedVersion(s), s.CurrentVersion())
+}
+
+// LayoutIndex picks which of the layout options to select, sticking with the previous aircraft when the new version has it.
+// When it doesn't, the status says where it can still be found.
+func LayoutIndex(s *state.State, options []string, prev string) int {
+	for i, o := range options {
+		if o == prev {
+			return i
+		}
+	}
+
+	if prev != "" && prev != "-Custom-" {
+		if vers := s.Versions().LayoutHistory(s.Board().Name)[prev]; len(vers) > 0 {
+			s.SetStatus(fmt.Sprintf("%s isn't in %s, latest is %s", prev, s.CurrentVersion(), vers[0]))
+		}
+	}
+	return 0
+}
+
+// PruneCache trims old and excess files from the temp dir. A flash uses the same files,
+// so it's held off (the same way as a second flash) until the prune is done.
+func PruneCache(s *state.State, maxAge time.Duration, maxSize int64) (int64, error) {
+	if !s.StartFlashing() {
+		return 0, fmt.Errorf("can't clean up while flashing")
+	}
+	defer s.FinishFlashing()
+	return cache.Prune(s.TmpDir, maxAge, maxSize)
+}
+
+// ClearCache removes one category of cached files from the temp dir, holding off flashing like PruneCache
+func ClearCache(s *state.State, c cache.Category) (int64, error) {
+	if !s.StartFlashing() {
+		return 0, fmt.Errorf("can't clean up while flashing")
+	}
+	defer s.FinishFlashing()
+	return cache.Clear(s.TmpDir, c)
 }
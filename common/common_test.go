@@ -0,0 +1,131 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/ports"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+func newTestState(t *testing.T) *state.State {
+	t.Helper()
+	// keep the config and arduino folders out of the real ones
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+	s, err := state.NewState("CLI", func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func usbPort(i int) *ports.Info {
+	return &ports.Info{
+		Port:         &rpc.Port{Address: fmt.Sprintf("/dev/ttyUSB%d", i), Protocol: "serial"},
+		VID:          "1a86",
+		PID:          "7523",
+		SerialNumber: fmt.Sprint(i),
+		Kind:         ports.KIND_CONTROLLER,
+	}
+}
+
+func TestPortListMapping(t *testing.T) {
+	s := newTestState(t)
+	s.AddPort(usbPort(0))
+	s.AddPort(usbPort(1))
+
+	l := &PortList{}
+	labels, selected := l.Update(s)
+	if len(labels) != 2 {
+		t.Fatalf("got %d labels, want 2", len(labels))
+	}
+	if selected < 0 || l.Address(selected) != s.CurrentPort() {
+		t.Errorf("selected %d doesn't match the current port %s", selected, s.CurrentPort())
+	}
+	for i, label := range labels {
+		if l.AddressOf(label) != l.Address(i) || l.Address(i) == "" {
+			t.Errorf("%s maps to %q and %q", label, l.AddressOf(label), l.Address(i))
+		}
+	}
+	if l.Address(-1) != "" || l.Address(len(labels)) != "" || l.AddressOf("nope") != "" {
+		t.Error("out of range lookups should be empty")
+	}
+}
+
+// TestPortListConcurrent is the port watcher redrawing a frontend's list while the user picks from it.
+// It's mostly here for go test -race.
+func TestPortListConcurrent(t *testing.T) {
+	s := newTestState(t)
+	l := &PortList{}
+	s.Subscribe(func(c state.Change) {
+		if c == state.PortsChanged {
+			l.Update(s)
+		}
+	})
+
+	const rounds = 200
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			s.AddPort(usbPort(i % 4))
+			if i%3 == 0 {
+				s.RemovePort(usbPort(i % 4).Address)
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			labels, _ := l.Update(s)
+			if len(labels) > 0 {
+				s.SetCurrentPort(l.AddressOf(labels[i%len(labels)]))
+			}
+			s.SetCurrentPort(l.Address(i % 4))
+			VisiblePorts(s)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestPruneCacheHoldsOffFlashing(t *testing.T) {
+	s := newTestState(t)
+
+	if !s.StartFlashing() {
+		t.Fatal("couldn't start flashing")
+	}
+	if _, err := PruneCache(s, cache.DEFAULT_MAX_AGE, cache.DEFAULT_MAX_SIZE); err == nil {
+		t.Error("pruned mid flash")
+	}
+	if _, err := ClearCache(s, cache.CATEGORY_HEXES); err == nil {
+		t.Error("cleared mid flash")
+	}
+	s.FinishFlashing()
+
+	// a flash can't start while the prune runs
+	started := make(chan bool, 1)
+	s.Subscribe(func(c state.Change) {
+		if c == state.ReadyChanged && !s.Ready().NotFlashing {
+			started <- s.StartFlashing()
+		}
+	})
+	if _, err := PruneCache(s, cache.DEFAULT_MAX_AGE, cache.DEFAULT_MAX_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	if <-started {
+		t.Error("flash started during the prune")
+	}
+	if !s.Ready().NotFlashing {
+		t.Error("still held off after the prune")
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	CONFIG_DIR_NAME  = "LEDControllerUpdater"
+	CONFIG_FILE_NAME = "config.json"
+
+	ENV_FIRMWARE_SOURCE = "LEDCU_FIRMWARE_SOURCE"
+	ENV_GITHUB_TOKEN    = "LEDCU_GITHUB_TOKEN"
+	ENV_ARDUINO_DIR     = "LEDCU_ARDUINO_DIR"
+)
+
+// Config is everything the user can change that sticks around between runs
+type Config struct {
+	// Firmware is the name of the selected firmware profile
+	Firmware string `json:"firmware,omitempty"`
+
+	// FirmwareSources overrides where each firmware's releases come from, see releases.ParseSource
+	FirmwareSources map[string]string `json:"firmware_sources,omitempty"`
+
+	// Channel is "stable", "beta" or "all", for both firmware and app updates
+	Channel string `json:"channel,omitempty"`
+
+	// Flashed remembers the last firmware version flashed to each board, see common.FlashedVersion
+	Flashed map[string]string `json:"flashed,omitempty"`
+
+	// GithubToken is sent with github api requests, for a much higher rate limit
+	GithubToken string `json:"github_token,omitempty"`
+
+	// ArduinoDir is where arduino-cli keeps its cores and libraries, see ArduinoDir. Takes effect on restart.
+	ArduinoDir string `json:"arduino_dir,omitempty"`
+
+	// HideOtherPorts leaves bluetooth, built in and other non usb-serial ports out of the port list
+	HideOtherPorts bool `json:"hide_other_ports,omitempty"`
+
+	// SourceOverride replaces the source of whichever firmware is selected, for this run only
+	SourceOverride string `json:"-"`
+}
+
+// SourceFor returns the release source spec for a firmware, or "" to use its default
+func (c Config) SourceFor(firmware string) string {
+	if c.SourceOverride != "" {
+		return c.SourceOverride
+	}
+	return c.FirmwareSources[firmware]
+}
+
+var (
+	mu        sync.RWMutex
+	saved     Config
+	path      string
+	overrides []func(c *Config)
+)
+
+// Load reads the config file, if there is one. A missing file just means defaults.
+func Load() error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	path = filepath.Join(dir, CONFIG_FILE_NAME)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &saved)
+}
+
+// Get returns the current config, with environment variables and any overrides applied on top
+func Get() Config {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c := saved
+	if v := os.Getenv(ENV_FIRMWARE_SOURCE); v != "" {
+		c.SourceOverride = v
+	}
+	if v := os.Getenv(ENV_ARDUINO_DIR); v != "" {
+		c.ArduinoDir = v
+	}
+	// also pick up the token the gh cli and most CI setups use
+	for _, env := range []string{ENV_GITHUB_TOKEN, "GITHUB_TOKEN"} {
+		if v := os.Getenv(env); v != "" {
+			c.GithubToken = v
+			break
+		}
+	}
+	for _, fn := range overrides {
+		fn(&c)
+	}
+	return c
+}
+
+// Override changes the config for this run only (command line flags, etc.), without saving
+func Override(fn func(c *Config)) {
+	mu.Lock()
+	defer mu.Unlock()
+	overrides = append(overrides, fn)
+}
+
+// Update changes the saved config through fn and writes it back out
+func Update(fn func(c *Config)) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if saved.FirmwareSources == nil {
+		saved.FirmwareSources = make(map[string]string)
+	}
+	if saved.Flashed == nil {
+		saved.Flashed = make(map[string]string)
+	}
+	fn(&saved)
+
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
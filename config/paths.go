@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// PORTABLE_MARKER next to the executable (or the .app on mac) switches on portable mode
+	PORTABLE_MARKER   = "portable.txt"
+	PORTABLE_DIR_NAME = "LEDControllerUpdaterData"
+
+	ARDUINO_DIR_NAME = "arduino"
+	CACHE_DIR_NAME   = "cache"
+
+	ENV_PORTABLE = "LEDCU_PORTABLE"
+)
+
+// exeDir is the folder the app sits in. On mac that's the one holding the .app bundle, since
+// anything inside the bundle is thrown away along with it on update.
+func exeDir() (string, error) {
+	en, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	en, err = filepath.EvalSymlinks(en)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "darwin" {
+		for dir := filepath.Dir(en); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if strings.HasSuffix(dir, ".app") {
+				return filepath.Dir(dir), nil
+			}
+		}
+	}
+	return filepath.Dir(en), nil
+}
+
+// Portable is true when everything should live next to the executable instead of the user's folders,
+// so the app can be carried around on a usb stick
+func Portable() bool {
+	if os.Getenv(ENV_PORTABLE) != "" {
+		return true
+	}
+	dir, err := exeDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, PORTABLE_MARKER))
+	return err == nil
+}
+
+// Dir is where the config file lives, and the arduino data unless it's been moved
+func Dir() (string, error) {
+	if Portable() {
+		dir, err := exeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, PORTABLE_DIR_NAME), nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, CONFIG_DIR_NAME), nil
+}
+
+// ArduinoDir is our own arduino-cli data directory, kept apart from any arduino ide install.
+// Relative paths are relative to Dir, so a portable install still works when the drive letter changes.
+func ArduinoDir() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	arduinoDir := Get().ArduinoDir
+	if arduinoDir == "" {
+		arduinoDir = ARDUINO_DIR_NAME
+	}
+	if !filepath.IsAbs(arduinoDir) {
+		arduinoDir = filepath.Join(dir, arduinoDir)
+	}
+	return arduinoDir, nil
+}
@@ -0,0 +1,32 @@
+package doctor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+// checkCH340 looks for the CH340 driver, which only windows doesn't ship with
+func checkCH340(s *state.State) Result {
+	r := Result{Name: "CH340 driver"}
+	if runtime.GOOS != "windows" {
+		r.Detail = "built in"
+		return r
+	}
+
+	driver := filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "CH341SER.SYS")
+	if _, err := os.Stat(driver); err != nil {
+		r.Status, r.Detail = WARN, "not installed"
+		r.Repair = func(ctx context.Context) error {
+			common.InstallCH340(s)
+			return nil
+		}
+		return r
+	}
+	r.Detail = driver
+	return r
+}
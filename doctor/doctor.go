@@ -0,0 +1,237 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arduino/arduino-cli/configuration"
+	"github.com/reyemxela/LEDControllerUpdater/arduino"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/config"
+	"github.com/reyemxela/LEDControllerUpdater/releases"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+const (
+	// MIN_FREE_SPACE is how much room the arduino data dir and temp dir should have for cores, libraries and builds
+	MIN_FREE_SPACE = 500 << 20
+
+	NETWORK_TIMEOUT = 10 * time.Second
+)
+
+// ARDUINO_HOST is where cores and libraries are downloaded from. Releases come from the firmware's own source.
+const ARDUINO_HOST = "https://downloads.arduino.cc"
+
+type Status int
+
+const (
+	OK Status = iota
+	WARN
+	FAIL
+)
+
+func (st Status) String() string {
+	switch st {
+	case OK:
+		return "OK"
+	case WARN:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// Result is the outcome of one check. Repair is set when we know how to fix the problem ourselves.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+	Repair func(ctx context.Context) error
+}
+
+// Run goes through every check in order
+func Run(ctx context.Context, s *state.State) []Result {
+	dataDir := configuration.Settings.GetString("directories.Data")
+	return []Result{
+		checkDataDir(dataDir),
+		checkCore(s),
+		checkLibraries(s),
+		checkSerial(s),
+		checkCH340(s),
+		checkDiskSpace("Disk space (arduino)", dataDir),
+		checkDiskSpace("Disk space (temp)", s.TmpDir),
+		checkNetwork(ctx, s),
+	}
+}
+
+// Repairable lists the results that failed but can be fixed
+func Repairable(results []Result) []Result {
+	o := []Result{}
+	for _, r := range results {
+		if r.Status != OK && r.Repair != nil {
+			o = append(o, r)
+		}
+	}
+	return o
+}
+
+func checkDataDir(dir string) Result {
+	r := Result{Name: "Arduino data dir"}
+	if dir == "" {
+		r.Status, r.Detail = FAIL, "not configured"
+		return r
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		r.Status, r.Detail = FAIL, err.Error()
+		return r
+	}
+
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		r.Status, r.Detail = FAIL, fmt.Sprintf("%s isn't writable: %s", dir, err.Error())
+		return r
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	r.Detail = dir
+	return r
+}
+
+func checkCore(s *state.State) Result {
+	r := Result{Name: "Arduino AVR core"}
+	r.Repair = func(ctx context.Context) error {
+		err := arduino.ReinstallCore(ctx, s)
+		common.CheckArduino(s)
+		return err
+	}
+
+	ver, err := arduino.InstalledCore(s.Instance())
+	switch {
+	case err != nil:
+		r.Status, r.Detail = FAIL, err.Error()
+	case ver == "":
+		r.Status, r.Detail = FAIL, "not installed"
+	default:
+		r.Detail = ver
+		// the core can be listed as installed but still be missing files after an interrupted install
+		platform := filepath.Join(configuration.Settings.GetString("directories.Data"), "packages", "arduino", "hardware", "avr", ver, "platform.txt")
+		if _, err := os.Stat(platform); err != nil {
+			r.Status, r.Detail = FAIL, ver+" is incomplete"
+		}
+	}
+	return r
+}
+
+// checkLibraries looks at the pinned copies a custom build of the selected version compiles against,
+// whatever's installed in the normal libraries folder doesn't get used
+func checkLibraries(s *state.State) Result {
+	r := Result{Name: "Arduino libraries"}
+	if ver := s.CurrentVersion(); ver != "" {
+		r.Name += " (" + ver + ")"
+	}
+	if s.Firmware().Schema == nil {
+		r.Detail = "not needed, " + s.Firmware().Name + " has no custom builds"
+		return r
+	}
+
+	libs, err := arduino.SelectedLibraries(s)
+	if err != nil {
+		r.Status, r.Detail = FAIL, err.Error()
+		return r
+	}
+	r.Repair = func(ctx context.Context) error {
+		return arduino.RepinLibraries(ctx, s, libs)
+	}
+
+	missing, damaged := arduino.CheckPinnedLibraries(libs)
+	switch {
+	case len(damaged) > 0:
+		r.Status, r.Detail = FAIL, strings.Join(damaged, ", ")+" damaged"
+		return r
+	case len(missing) > 0:
+		// they're fetched on the first custom build anyway, but that needs the network
+		r.Status, r.Detail = WARN, strings.Join(missing, ", ")+" not downloaded yet"
+		return r
+	}
+	found := []string{}
+	for _, l := range libs {
+		found = append(found, l.Name+" "+l.Version)
+	}
+	r.Detail = strings.Join(found, ", ")
+	return r
+}
+
+func checkDiskSpace(name string, dir string) Result {
+	r := Result{Name: name}
+	free, err := freeSpace(dir)
+	if err != nil {
+		r.Status, r.Detail = WARN, err.Error()
+		return r
+	}
+	r.Detail = cache.FormatSize(free) + " free in " + dir
+	if free < MIN_FREE_SPACE {
+		r.Status = WARN
+	}
+	return r
+}
+
+// networkHosts are the servers we need to reach to download cores, libraries and the selected firmware's releases
+func networkHosts(s *state.State) ([]string, error) {
+	hosts := []string{ARDUINO_HOST}
+
+	fw := s.Firmware()
+	spec := config.Get().SourceFor(fw.Name)
+	if spec == "" {
+		spec = fw.Source
+	}
+	src, err := releases.ParseSource(spec)
+	if err != nil {
+		return hosts, err
+	}
+	// a local index needs no network
+	if host := src.Host(); host != "" {
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func checkNetwork(ctx context.Context, s *state.State) Result {
+	r := Result{Name: "Network"}
+	client := &http.Client{Timeout: NETWORK_TIMEOUT}
+
+	hosts, err := networkHosts(s)
+	if err != nil {
+		r.Status, r.Detail = WARN, "release source: "+err.Error()
+	}
+
+	failed := []string{}
+	for _, host := range hosts {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, host, nil)
+		if err == nil {
+			var resp *http.Response
+			resp, err = client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+		if err != nil {
+			failed = append(failed, host)
+		}
+	}
+
+	switch {
+	case len(failed) == len(hosts):
+		r.Status, r.Detail = FAIL, "can't reach "+strings.Join(failed, ", ")
+	case len(failed) > 0:
+		r.Status, r.Detail = WARN, "can't reach "+strings.Join(failed, ", ")
+	case r.Status == OK:
+		r.Detail = strings.Join(hosts, ", ") + " reachable"
+	}
+	return r
+}
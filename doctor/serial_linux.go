@@ -0,0 +1,55 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/reyemxela/LEDControllerUpdater/arduino"
+	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/state"
+)
+
+// access() mode bits, which the syscall package doesn't export
+const (
+	R_OK = 0x4
+	W_OK = 0x2
+)
+
+// checkSerial makes sure we can open the usb serial devices, through the udev rule or the group that owns them (usually dialout or uucp)
+func checkSerial(s *state.State) Result {
+	r := Result{Name: "Serial port permissions"}
+
+	devices := []string{}
+	for _, pattern := range []string{"/dev/ttyUSB*", "/dev/ttyACM*"} {
+		m, _ := filepath.Glob(pattern)
+		devices = append(devices, m...)
+	}
+	if len(devices) == 0 {
+		r.Detail = "no usb serial devices plugged in"
+		return r
+	}
+
+	problems := []string{}
+	for _, dev := range devices {
+		// access() goes by the real permissions, acls from the udev rule's uaccess tag included
+		if err := syscall.Access(dev, R_OK|W_OK); err == nil {
+			continue
+		}
+
+		group, _ := arduino.PortGroup(dev)
+		problems = append(problems, fmt.Sprintf("%s needs the udev rule or group %s (sudo usermod -aG %s $USER, then log out and back in)", dev, group, group))
+	}
+
+	if len(problems) > 0 {
+		r.Status, r.Detail = FAIL, strings.Join(problems, "; ")
+		r.Repair = func(ctx context.Context) error {
+			return common.InstallUdevRule(s)
+		}
+	} else {
+		r.Detail = strings.Join(devices, ", ")
+	}
+	return r
+}
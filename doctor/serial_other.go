@@ -0,0 +1,10 @@
+//go:build !linux
+
+package doctor
+
+import "github.com/reyemxela/LEDControllerUpdater/state"
+
+// only linux locks serial ports behind a group
+func checkSerial(s *state.State) Result {
+	return Result{Name: "Serial port permissions", Detail: "nothing to check on this system"}
+}
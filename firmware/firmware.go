@@ -0,0 +1,192 @@
+package firmware
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/reyemxela/LEDControllerUpdater/layout"
+	"github.com/reyemxela/LEDControllerUpdater/releases"
+	"github.com/reyemxela/LEDControllerUpdater/semver"
+)
+
+const (
+	DEFAULT_FIRMWARE = "LEDController"
+)
+
+// Board is one generation of controller hardware
+type Board struct {
+	Name string
+	FQBN string
+	// OldBootloaderFQBN is the same board with the old, slower nano bootloader that a lot of clones still ship with
+	OldBootloaderFQBN string
+
+	// USBIDs are the "vid:pid" pairs of the board's usb-serial chip, so its port can go first in the list
+	USBIDs []string
+}
+
+var (
+	BOARD_V1 = &Board{
+		Name:              "v1",
+		FQBN:              "arduino:avr:nano:cpu=atmega328",
+		OldBootloaderFQBN: "arduino:avr:nano:cpu=atmega328old",
+		USBIDs:            []string{"1a86:7523"}, // CH340
+	}
+)
+
+// Library is an arduino library a firmware version is built against
+type Library struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// LibrarySet is the libraries needed from firmware version Since onwards, until the next set takes over
+type LibrarySet struct {
+	Since     string
+	Libraries []Library
+}
+
+// DEFAULT_LIBRARIES is what every firmware has been built with so far.
+// BMP280 stays on 2.3.0, 2.4.0 adds ~1KB and flash is already tight.
+// Dependencies have to be listed too, they're pinned like everything else rather than pulled in at whatever version is newest.
+var DEFAULT_LIBRARIES = []LibrarySet{
+	{
+		Since: "",
+		Libraries: []Library{
+			{Name: "FastLED", Version: "3.4.0"},
+			{Name: "Adafruit BMP280 Library", Version: "2.3.0"},
+			{Name: "Adafruit BusIO", Version: "1.7.3"},
+			{Name: "Adafruit Unified Sensor", Version: "1.1.4"},
+		},
+	},
+}
+
+// Profile is everything that differs between firmware repositories
+type Profile struct {
+	Name string
+
+	// Source is the default release source spec, see releases.ParseSource
+	Source string
+
+	// Assets matches hex filenames. An optional "board" group says which board the hex is for.
+	Assets *regexp.Regexp
+
+	Boards []*Board
+
+	// Sketch is the main .ino file in the source zip
+	Sketch string
+
+	// Schema is how custom layouts are compiled in, nil if custom builds aren't supported
+	Schema *layout.Schema
+
+	// Libraries maps firmware versions to the libraries they need, oldest first.
+	// A libraries.json in the firmware source overrides this.
+	Libraries []LibrarySet
+}
+
+// Profiles lists every supported firmware repo
+var Profiles = []*Profile{
+	{
+		Name:   "LEDController",
+		Source: releases.DEFAULT_SOURCE,
+		Assets: regexp.MustCompile(`^(?P<layout>.+?)_(?P<version>v\d+\.\d+\.\d+.*)\.hex$`),
+		Boards: []*Board{BOARD_V1},
+		Sketch: "LEDController.ino",
+		Schema: layout.V1_SCHEMA,
+
+		Libraries: DEFAULT_LIBRARIES,
+	},
+}
+
+// Get looks up a profile by name, falling back to the default one
+func Get(name string) *Profile {
+	for _, p := range Profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	for _, p := range Profiles {
+		if p.Name == DEFAULT_FIRMWARE {
+			return p
+		}
+	}
+	return Profiles[0]
+}
+
+func Names() []string {
+	names := make([]string, len(Profiles))
+	for i, p := range Profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Board looks up one of the profile's boards by name, falling back to the first
+func (p *Profile) Board(name string) *Board {
+	for _, b := range p.Boards {
+		if b.Name == name {
+			return b
+		}
+	}
+	return p.Boards[0]
+}
+
+func (p *Profile) BoardNames() []string {
+	names := make([]string, len(p.Boards))
+	for i, b := range p.Boards {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// LibrariesFor returns the libraries a firmware version needs. An empty ver means the newest set.
+func (p *Profile) LibrariesFor(ver string) []Library {
+	var libs []Library
+	for _, set := range p.Libraries {
+		if ver == "" || set.Since == "" || !semver.Newer(set.Since, ver) {
+			libs = set.Libraries
+		}
+	}
+	return libs
+}
+
+// MatchAsset is a releases.AssetMatcher for this profile's asset naming.
+// Hexes for boards the profile doesn't know about are skipped.
+func (p *Profile) MatchAsset(name string) (releases.AssetInfo, bool) {
+	info := releases.AssetInfo{}
+	m := p.Assets.FindStringSubmatch(name)
+	if m == nil {
+		return info, false
+	}
+
+	group := func(name string) string {
+		if i := p.Assets.SubexpIndex(name); i >= 0 {
+			return m[i]
+		}
+		return ""
+	}
+	info.Layout = group("layout")
+	info.Version = group("version")
+	info.Board = group("board")
+
+	if info.Board != "" {
+		for _, b := range p.Boards {
+			if b.Name == info.Board {
+				return info, true
+			}
+		}
+		return info, false
+	}
+	return info, true
+}
+
+// GetVersions lists this firmware's versions on a channel, from spec if given, or the profile's own source
+func (p *Profile) GetVersions(ctx context.Context, spec string, channel releases.Channel) (releases.Versions, error) {
+	if spec == "" {
+		spec = p.Source
+	}
+	src, err := releases.ParseSource(spec)
+	if err != nil {
+		return releases.Versions{}, err
+	}
+	return releases.GetVersions(ctx, src, p.MatchAsset, channel)
+}
@@ -0,0 +1,57 @@
+package firmware
+
+import (
+	"fmt"
+)
+
+const (
+	// arduino itself warns about stability past 75% RAM, and FastLED's led buffers count toward it
+	RAM_WARN  = 0.75
+	RAM_BLOCK = 0.95
+
+	FLASH_WARN  = 0.95
+	FLASH_BLOCK = 1.0
+)
+
+// SizeReport is how much of the board's memory a build uses
+type SizeReport struct {
+	Flash    int64 `json:"flash"`
+	FlashMax int64 `json:"flash_max"`
+	RAM      int64 `json:"ram"`
+	RAMMax   int64 `json:"ram_max"`
+}
+
+func percent(used int64, max int64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return float64(used) / float64(max)
+}
+
+func (r *SizeReport) FlashUsage() float64 {
+	return percent(r.Flash, r.FlashMax)
+}
+
+func (r *SizeReport) RAMUsage() float64 {
+	return percent(r.RAM, r.RAMMax)
+}
+
+func (r *SizeReport) String() string {
+	return fmt.Sprintf("Flash: %d/%d bytes (%.0f%%), RAM: %d/%d bytes (%.0f%%)",
+		r.Flash, r.FlashMax, r.FlashUsage()*100, r.RAM, r.RAMMax, r.RAMUsage()*100)
+}
+
+// Check returns an error if the build is too big to run safely, and a warning if it's getting close
+func (r *SizeReport) Check() (warning string, err error) {
+	switch {
+	case r.FlashUsage() >= FLASH_BLOCK:
+		return "", fmt.Errorf("build is too big for the board (%s)", r)
+	case r.RAMUsage() >= RAM_BLOCK:
+		return "", fmt.Errorf("build needs too much RAM, try fewer LEDs (%s)", r)
+	case r.FlashUsage() >= FLASH_WARN:
+		return fmt.Sprintf("Flash is nearly full (%.0f%%)", r.FlashUsage()*100), nil
+	case r.RAMUsage() >= RAM_WARN:
+		return fmt.Sprintf("RAM is getting low (%.0f%%), fewer LEDs would be safer", r.RAMUsage()*100), nil
+	}
+	return "", nil
+}
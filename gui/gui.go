@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"runtime"
 	"time"
@@ -10,10 +11,17 @@ import (
 	"github.com/reyemxela/LEDControllerUpdater/common"
 	"github.com/reyemxela/LEDControllerUpdater/state"
 	"github.com/reyemxela/LEDControllerUpdater/update"
-	"github.com/reyemxela/LEDControllerUpdater/utils"
 )
 
 func main() {
+	// the updater runs new versions with -version to make sure they work before switching over
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *version {
+		fmt.Println(state.APP_VERSION)
+		return
+	}
+
 	if runtime.GOOS == "windows" {
 		hideConsole()
 	}
@@ -30,18 +38,32 @@ func main() {
 
 	ui.mainWindow = ui.app.NewWindow(state.APP_NAME)
 	ui.mainWindow.SetContent(createMainWindow(ui))
+	ui.mainWindow.SetMainMenu(createMainMenu(ui))
 
-	go arduino.WatchPorts(ui.state, func() {
-		ui.portList.Options = utils.ListKeys(ui.state.Ports)
-		if len(ui.portList.Options) < 1 {
-			ui.portList.ClearSelected()
-		} else {
-			ui.portList.SetSelected(ui.state.CurrentPort)
+	ui.state.Subscribe(func(c state.Change) {
+		switch c {
+		case state.PortsChanged:
+			ui.setPorts()
+			ui.setNotes()
+		case state.SelectionChanged:
+			ui.setNotes()
+		case state.VersionsChanged:
+			ui.setVersions()
+		case state.FirmwareChanged:
+			ui.setFirmware()
+		case state.BoardChanged:
+			ui.boardSelect.SetSelected(ui.state.Board().Name)
+			ui.setLayouts()
+		case state.ReadyChanged:
+			ui.setFlashing()
 		}
 	})
 
+	go arduino.WatchPorts(ui.state)
+
 	go func() {
-		common.Init(ui.state, ui.setVersions)
+		common.Init(ui.state)
+		update.CleanOldVersions(ui.state)
 
 		time.Sleep(1 * time.Second)
 
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net/url"
 	"runtime"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
 	"github.com/reyemxela/LEDControllerUpdater/arduino"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
 	"github.com/reyemxela/LEDControllerUpdater/common"
+	"github.com/reyemxela/LEDControllerUpdater/config"
+	"github.com/reyemxela/LEDControllerUpdater/doctor"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	ledlayout "github.com/reyemxela/LEDControllerUpdater/layout"
+	"github.com/reyemxela/LEDControllerUpdater/releases"
 	"github.com/reyemxela/LEDControllerUpdater/state"
 	"github.com/reyemxela/LEDControllerUpdater/update"
 	"github.com/reyemxela/LEDControllerUpdater/utils"
@@ -20,29 +27,55 @@ type UI struct {
 	app   fyne.App
 	state *state.State
 
-	verSelect    *widget.Select
-	layoutSelect *widget.Select
+	firmwareSelect *widget.Select
+	boardSelect    *widget.Select
+	verSelect      *widget.Select
+	layoutSelect   *widget.Select
+
+	notes        *widget.RichText
+	notesSection *container.Scroll
 
 	mainWindow    fyne.Window
 	customSection *fyne.Container
 	flashSection  *fyne.Container
 
-	portList *widget.Select
+	portList  *widget.Select
+	ports     common.PortList
+	cancelBtn *widget.Button
 
 	statusBar *widget.Label
 }
 
+func createFirmwareSelect(ui *UI) {
+	ui.firmwareSelect = widget.NewSelect(firmware.Names(), func(value string) {
+		common.SetFirmware(ui.state, value)
+	})
+	ui.firmwareSelect.SetSelected(ui.state.Firmware().Name)
+
+	ui.boardSelect = widget.NewSelect(ui.state.Firmware().BoardNames(), func(value string) {
+		ui.state.SetBoard(value)
+	})
+	ui.boardSelect.SetSelected(ui.state.Board().Name)
+}
+
 func createVerSelect(ui *UI) {
 	ui.verSelect = widget.NewSelect(nil, func(value string) {
-		ui.state.CurrentVersion = value
-		ui.layoutSelect.Options = append(utils.ListKeys(ui.state.Versions[value]), "-Custom-")
-		ui.layoutSelect.SetSelectedIndex(0)
+		ui.state.SetCurrentVersion(value)
+		ui.setLayouts()
 	})
 }
 
+func createNotesSection(ui *UI) {
+	ui.notes = widget.NewRichTextFromMarkdown("")
+	ui.notes.Wrapping = fyne.TextWrapWord
+
+	ui.notesSection = container.NewVScroll(ui.notes)
+	ui.notesSection.SetMinSize(fyne.NewSize(350, 180))
+}
+
 func createLayoutSelect(ui *UI) {
 	ui.layoutSelect = widget.NewSelect([]string{}, func(value string) {
-		ui.state.CurrentLayout = value
+		ui.state.SetCurrentLayout(value)
 		if value == "-Custom-" {
 			ui.showCustomSection()
 		} else {
@@ -53,40 +86,31 @@ func createLayoutSelect(ui *UI) {
 
 func createFlashSection(ui *UI) {
 	ui.portList = widget.NewSelect([]string{}, func(value string) {
-		ui.state.CurrentPort = value
-		if value == "" {
-			ui.state.Ready.PortSelected = false
-		} else {
-			ui.state.Ready.PortSelected = true
-		}
+		ui.state.SetCurrentPort(ui.ports.AddressOf(value))
 	})
 	ui.portList.PlaceHolder = "(Select COM port)"
 
+	ui.cancelBtn = widget.NewButton("Cancel", func() {
+		ui.state.CancelOperation()
+	})
+	ui.cancelBtn.Disable()
+
 	flashBtn := widget.NewButton("Flash Firmware", func() {
-		if ui.state.CheckReady() {
-			go func() {
-				ui.state.Ready.NotFlashing = false
-				err := arduino.DoFlash(ui.state)
-				if err != nil {
-					ui.state.SetStatus(err.Error())
-				} else {
-					ui.state.SetStatus("Done!")
-				}
-				ui.state.Ready.NotFlashing = true
-			}()
-		}
+		common.Flash(ui.state)
 	})
 
 	ui.flashSection = container.NewGridWithColumns(2,
 		container.NewVBox(
 			ui.portList,
-			widget.NewLabel(""),
+			ui.cancelBtn,
 		),
 		flashBtn,
 	)
 }
 
 func createCustomSection(ui *UI) {
+	custom := ui.state.CustomLayout()
+
 	wingLEDLabel := widget.NewLabel("Wing: ")
 	noseLEDLabel := widget.NewLabel("Nose: ")
 	fuseLEDLabel := widget.NewLabel("Fuse: ")
@@ -100,24 +124,24 @@ func createCustomSection(ui *UI) {
 	navLEDSlider := widget.NewSlider(0, 50)
 
 	wingRevCheck := widget.NewCheck("Reversed?", func(checked bool) {
-		ui.state.CustomLayout.WingRev = checked
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.WingRev = checked })
 	})
 	noseRevCheck := widget.NewCheck("Reversed?", func(checked bool) {
-		ui.state.CustomLayout.NoseRev = checked
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.NoseRev = checked })
 	})
 	fuseRevCheck := widget.NewCheck("Reversed?", func(checked bool) {
-		ui.state.CustomLayout.FuseRev = checked
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.FuseRev = checked })
 	})
 	tailRevCheck := widget.NewCheck("Reversed?", func(checked bool) {
-		ui.state.CustomLayout.TailRev = checked
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.TailRev = checked })
 	})
 	noseFuseJoinCheck := widget.NewCheck("Nose/Fuse joined?", func(checked bool) {
-		ui.state.CustomLayout.NoseFuseJoin = checked
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.NoseFuseJoin = checked })
 	})
 
 	wingLEDSlider.OnChanged = func(value float64) {
 		wingLEDLabel.SetText("Wing: " + fmt.Sprint(value))
-		ui.state.CustomLayout.WingLEDs = int(value)
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.WingLEDs = int(value) })
 		navLEDSlider.Max = value
 		if navLEDSlider.Value > value {
 			navLEDSlider.OnChanged(value)
@@ -127,29 +151,29 @@ func createCustomSection(ui *UI) {
 
 	noseLEDSlider.OnChanged = func(value float64) {
 		noseLEDLabel.SetText("Nose: " + fmt.Sprint(value))
-		ui.state.CustomLayout.NoseLEDs = int(value)
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.NoseLEDs = int(value) })
 	}
 
 	fuseLEDSlider.OnChanged = func(value float64) {
 		fuseLEDLabel.SetText("Fuse: " + fmt.Sprint(value))
-		ui.state.CustomLayout.FuseLEDs = int(value)
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.FuseLEDs = int(value) })
 	}
 
 	tailLEDSlider.OnChanged = func(value float64) {
 		tailLEDLabel.SetText("Tail: " + fmt.Sprint(value))
-		ui.state.CustomLayout.TailLEDs = int(value)
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.TailLEDs = int(value) })
 	}
 
 	navLEDSlider.OnChanged = func(value float64) {
 		navLEDLabel.SetText("Nav LEDs: " + fmt.Sprint(value))
-		ui.state.CustomLayout.WingNavLEDs = int(value)
+		ui.state.UpdateCustomLayout(func(l *ledlayout.CustomLayout) { l.WingNavLEDs = int(value) })
 	}
 
-	wingLEDSlider.SetValue(float64(ui.state.CustomLayout.WingLEDs))
-	noseLEDSlider.SetValue(float64(ui.state.CustomLayout.NoseLEDs))
-	fuseLEDSlider.SetValue(float64(ui.state.CustomLayout.FuseLEDs))
-	tailLEDSlider.SetValue(float64(ui.state.CustomLayout.TailLEDs))
-	navLEDSlider.SetValue(float64(ui.state.CustomLayout.WingNavLEDs))
+	wingLEDSlider.SetValue(float64(custom.WingLEDs))
+	noseLEDSlider.SetValue(float64(custom.NoseLEDs))
+	fuseLEDSlider.SetValue(float64(custom.FuseLEDs))
+	tailLEDSlider.SetValue(float64(custom.TailLEDs))
+	navLEDSlider.SetValue(float64(custom.WingNavLEDs))
 	noseRevCheck.SetChecked(true)
 	noseFuseJoinCheck.SetChecked(true)
 
@@ -184,8 +208,10 @@ func createCustomSection(ui *UI) {
 }
 
 func createMainWindow(ui *UI) *fyne.Container {
+	createFirmwareSelect(ui)
 	createVerSelect(ui)
 	createLayoutSelect(ui)
+	createNotesSection(ui)
 	createFlashSection(ui)
 	createCustomSection(ui)
 
@@ -200,18 +226,35 @@ func createMainWindow(ui *UI) *fyne.Container {
 		driverBtn.Hide()
 	}
 
+	udevBtn := widget.NewButton("Serial Port Permissions", func() {
+		go func() {
+			if err := common.InstallUdevRule(ui.state); err != nil {
+				ui.state.SetStatus("Error: " + err.Error())
+			}
+		}()
+	})
+	// and the udev rule on linux only
+	if runtime.GOOS != "linux" {
+		udevBtn.Hide()
+	}
+
 	ui.statusBar = widget.NewLabel("")
 
 	mainSection := container.NewVBox(
 		titleLabel,
 		driverBtn,
+		udevBtn,
+		container.NewGridWithColumns(2, ui.firmwareSelect, ui.boardSelect),
 		ui.verSelect,
 		ui.layoutSelect,
 		ui.flashSection,
 	)
 
+	notesCard := container.NewBorder(widget.NewLabel("Release notes:"), nil, nil, nil, ui.notesSection)
+
 	mainPlusCustom := container.NewHBox(
 		mainSection,
+		notesCard,
 		ui.customSection,
 	)
 
@@ -219,55 +262,355 @@ func createMainWindow(ui *UI) *fyne.Container {
 }
 
 func (ui *UI) setVersions() {
-	ui.verSelect.Options = append(ui.verSelect.Options, utils.ListKeys(ui.state.Versions)...)
+	ui.verSelect.Options = utils.ListVersions(ui.state.Versions())
+	if len(ui.verSelect.Options) < 1 {
+		ui.verSelect.ClearSelected()
+		ui.setLayouts()
+		return
+	}
 	ui.verSelect.SetSelectedIndex(0)
 }
 
-func updatePopup(ver string, ui *UI) {
-	popup := ui.app.NewWindow("Update")
-	var content *fyne.Container
+func (ui *UI) setLayouts() {
+	if ui.state.Release() == nil {
+		ui.layoutSelect.Options = nil
+		ui.layoutSelect.ClearSelected()
+		return
+	}
 
-	if runtime.GOOS == "darwin" {
-		label := widget.NewLabel("New version available: " + ver + ".\n")
-		link, _ := url.Parse(fmt.Sprintf("%s/%s/%s", update.APP_RELEASE_URL_PREFIX, "tag", ver))
-		hyperlink := widget.NewHyperlink("Download", link)
+	prev := ui.state.CurrentLayout()
+	ui.layoutSelect.Options = utils.ListVersions(ui.state.Layouts())
+	if ui.state.Firmware().Schema != nil {
+		ui.layoutSelect.Options = append(ui.layoutSelect.Options, "-Custom-")
+	}
+	ui.layoutSelect.SetSelectedIndex(common.LayoutIndex(ui.state, ui.layoutSelect.Options, prev))
+}
 
-		content = container.NewVBox(
-			label,
-			container.NewHBox(
-				hyperlink,
-				layout.NewSpacer(),
-			),
-			widget.NewButton("OK", func() {
+func (ui *UI) setNotes() {
+	ui.notes.ParseMarkdown(common.ReleaseNotes(ui.state))
+	ui.notesSection.ScrollToTop()
+}
+
+func (ui *UI) setFirmware() {
+	ui.firmwareSelect.SetSelected(ui.state.Firmware().Name)
+	ui.boardSelect.Options = ui.state.Firmware().BoardNames()
+	ui.boardSelect.SetSelected(ui.state.Board().Name)
+}
+
+func updatePopup(ver string, ui *UI) {
+	popup := ui.app.NewWindow("Update")
+	label := widget.NewLabel("New version available: " + ver + ".\n\nWould you like to automatically install the update?")
+	content := container.NewVBox(
+		label,
+		layout.NewSpacer(),
+		container.NewGridWithColumns(2,
+			widget.NewButton("No", func() {
 				popup.Close()
 			}),
-		)
-	} else {
-		label := widget.NewLabel("New version available: " + ver + ".\n\nWould you like to automatically install the update?")
-		content = container.NewVBox(
-			label,
-			layout.NewSpacer(),
-			container.NewGridWithColumns(2,
-				widget.NewButton("No", func() {
-					popup.Close()
-				}),
-				widget.NewButton("Yes", func() {
-					popup.Close()
-
-					err := update.UpdateApp(ver, ui.state, func() { ui.app.Quit() })
-					if err != nil {
-						ui.state.SetStatus(err.Error())
-					}
-				}),
-			),
-		)
-	}
+			widget.NewButton("Yes", func() {
+				popup.Close()
+
+				err := update.UpdateApp(ver, ui.state, func() { ui.app.Quit() })
+				if err != nil {
+					ui.state.SetStatus(err.Error())
+				}
+			}),
+		),
+	)
 
 	popup.SetContent(content)
 	popup.CenterOnScreen()
 	popup.Show()
 }
 
+func createMainMenu(ui *UI) *fyne.MainMenu {
+	exportItem := fyne.NewMenuItem("Export arduino bundle...", func() {
+		dialog.ShowFileSave(func(w fyne.URIWriteCloser, err error) {
+			if err != nil || w == nil {
+				return
+			}
+			w.Close()
+			go func() {
+				if err := arduino.ExportBundle(ui.state, w.URI().Path()); err != nil {
+					ui.state.SetStatus("Error: " + err.Error())
+				} else {
+					ui.state.SetStatus("Bundle exported")
+				}
+			}()
+		}, ui.mainWindow)
+	})
+
+	importItem := fyne.NewMenuItem("Import arduino bundle...", func() {
+		dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil || r == nil {
+				return
+			}
+			r.Close()
+			go func() {
+				if err := common.ImportBundle(ui.state, r.URI().Path()); err != nil {
+					ui.state.SetStatus("Error: " + err.Error())
+				}
+			}()
+		}, ui.mainWindow)
+	})
+
+	sourceItem := fyne.NewMenuItem("Firmware source...", func() {
+		sourceDialog(ui)
+	})
+
+	settingsItem := fyne.NewMenuItem("Settings...", func() {
+		settingsWindow(ui)
+	})
+
+	hidePortsItem := fyne.NewMenuItem("Hide non-USB ports", nil)
+	hidePortsItem.Checked = config.Get().HideOtherPorts
+	hidePortsItem.Action = func() {
+		hidePortsItem.Checked = !hidePortsItem.Checked
+		common.SetHideOtherPorts(ui.state, hidePortsItem.Checked)
+		ui.mainWindow.MainMenu().Refresh()
+	}
+
+	doctorItem := fyne.NewMenuItem("Doctor...", func() {
+		doctorWindow(ui)
+	})
+
+	channelItem := fyne.NewMenuItem("Release channel...", func() {
+		channelDialog(ui)
+	})
+
+	return fyne.NewMainMenu(
+		fyne.NewMenu("Tools", sourceItem, channelItem, fyne.NewMenuItemSeparator(), exportItem, importItem, fyne.NewMenuItemSeparator(), hidePortsItem, fyne.NewMenuItemSeparator(), doctorItem, settingsItem),
+	)
+}
+
+func sourceDialog(ui *UI) {
+	fw := ui.state.Firmware()
+
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(fw.Source)
+	entry.SetText(config.Get().FirmwareSources[fw.Name])
+
+	help := widget.NewLabel("github:owner/repo\ngitea:https://host/owner/repo\ngitlab:https://host/group/project\nindex:https://host/path/index.json\nindex:/path/to/folder")
+
+	dialog.ShowCustomConfirm(fw.Name+" source", "Save", "Cancel", container.NewVBox(entry, help), func(ok bool) {
+		if !ok {
+			return
+		}
+		// empty means back to the firmware's default source
+		if entry.Text != "" {
+			if _, err := releases.ParseSource(entry.Text); err != nil {
+				ui.state.SetStatus("Error: " + err.Error())
+				return
+			}
+		}
+		err := config.Update(func(c *config.Config) {
+			c.FirmwareSources[fw.Name] = entry.Text
+		})
+		if err != nil {
+			ui.state.SetStatus("Error: " + err.Error())
+		}
+		go func() {
+			common.LoadVersions(ui.state)
+			ui.state.SetStatus("Ready")
+		}()
+	}, ui.mainWindow)
+}
+
+func channelDialog(ui *UI) {
+	options := make([]string, len(releases.CHANNELS))
+	for i, c := range releases.CHANNELS {
+		options[i] = string(c)
+	}
+
+	radio := widget.NewRadioGroup(options, nil)
+	radio.Required = true
+	radio.SetSelected(string(releases.ParseChannel(config.Get().Channel)))
+
+	help := widget.NewLabel("stable: normal releases only\nbeta: also pre-releases\nall: everything, including drafts")
+
+	dialog.ShowCustomConfirm("Release channel", "Save", "Cancel", container.NewVBox(radio, help), func(ok bool) {
+		if ok {
+			common.SetChannel(ui.state, releases.ParseChannel(radio.Selected))
+		}
+	}, ui.mainWindow)
+}
+
+// settingsWindow shows how much disk space the app's cache is using, with buttons to clean it up
+func settingsWindow(ui *UI) {
+	w := ui.app.NewWindow("Settings")
+
+	grid := container.NewGridWithColumns(3)
+	total := widget.NewLabel("")
+
+	var refresh func()
+	run := func(fn func() (int64, error)) {
+		freed, err := fn()
+		if err != nil {
+			ui.state.SetStatus("Error: " + err.Error())
+		} else {
+			ui.state.SetStatus("Freed " + cache.FormatSize(freed))
+		}
+		refresh()
+	}
+
+	refresh = func() {
+		grid.Objects = nil
+		usage, err := cache.GetUsage(ui.state.TmpDir)
+		if err != nil {
+			total.SetText("Error: " + err.Error())
+			return
+		}
+
+		var sum int64
+		for _, u := range usage {
+			c := u.Category
+			grid.Add(widget.NewLabel(string(c)))
+			grid.Add(widget.NewLabel(cache.FormatSize(u.Size)))
+			grid.Add(widget.NewButton("Clear", func() {
+				run(func() (int64, error) { return common.ClearCache(ui.state, c) })
+			}))
+			sum += u.Size
+		}
+		grid.Refresh()
+		total.SetText("Total: " + cache.FormatSize(sum))
+	}
+	refresh()
+
+	pruneBtn := widget.NewButton("Remove old files", func() {
+		run(func() (int64, error) {
+			return common.PruneCache(ui.state, cache.DEFAULT_MAX_AGE, cache.DEFAULT_MAX_SIZE)
+		})
+	})
+
+	arduinoDir, _ := config.ArduinoDir()
+	arduinoLabel := widget.NewLabel(arduinoDir)
+	changeBtn := widget.NewButton("Change...", func() {
+		dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+			if err != nil || dir == nil {
+				return
+			}
+			err = config.Update(func(c *config.Config) {
+				c.ArduinoDir = dir.Path()
+			})
+			if err != nil {
+				ui.state.SetStatus("Error: " + err.Error())
+				return
+			}
+			arduinoLabel.SetText(dir.Path())
+			ui.state.SetStatus("Restart to use the new arduino folder")
+		}, w)
+	})
+	arduinoTitle := "Arduino"
+	if config.Portable() {
+		arduinoTitle += " (portable)"
+	}
+
+	w.SetContent(container.NewVBox(
+		widget.NewCard(arduinoTitle, "cores and libraries", container.NewBorder(nil, nil, nil, changeBtn, arduinoLabel)),
+		widget.NewCard("Cache", ui.state.TmpDir, container.NewVBox(
+			grid,
+			widget.NewSeparator(),
+			container.NewGridWithColumns(2, total, pruneBtn),
+		)),
+		widget.NewButton("Close", func() { w.Close() }),
+	))
+	w.CenterOnScreen()
+	w.Show()
+}
+
+// doctorWindow runs the health checks, with a Repair button next to anything we know how to fix
+func doctorWindow(ui *UI) {
+	w := ui.app.NewWindow("Doctor")
+
+	grid := container.NewGridWithColumns(4)
+	status := widget.NewLabel("")
+	checkBtn := widget.NewButton("Check again", nil)
+
+	var check func()
+	check = func() {
+		checkBtn.Disable()
+		status.SetText("Checking...")
+		grid.Objects = nil
+		grid.Refresh()
+
+		go func() {
+			ctx := context.Background()
+			results := doctor.Run(ctx, ui.state)
+
+			for _, r := range results {
+				r := r
+				grid.Add(widget.NewLabel(r.Status.String()))
+				grid.Add(widget.NewLabel(r.Name))
+				detail := widget.NewLabel(r.Detail)
+				detail.Wrapping = fyne.TextWrapWord
+				grid.Add(detail)
+
+				if r.Status == doctor.OK || r.Repair == nil {
+					grid.Add(layout.NewSpacer())
+					continue
+				}
+				var btn *widget.Button
+				btn = widget.NewButton("Repair", func() {
+					btn.Disable()
+					status.SetText("Repairing " + r.Name + "...")
+					go func() {
+						if err := r.Repair(ctx); err != nil {
+							ui.state.SetStatus("Error: " + err.Error())
+						}
+						check()
+					}()
+				})
+				grid.Add(btn)
+			}
+			grid.Refresh()
+
+			if n := len(doctor.Repairable(results)); n > 0 {
+				status.SetText(fmt.Sprintf("%d problem(s) can be repaired", n))
+			} else {
+				status.SetText("")
+			}
+			checkBtn.Enable()
+		}()
+	}
+	checkBtn.OnTapped = check
+	check()
+
+	w.SetContent(container.NewVBox(
+		grid,
+		widget.NewSeparator(),
+		container.NewGridWithColumns(2, status, checkBtn),
+		widget.NewButton("Close", func() { w.Close() }),
+	))
+	w.Resize(fyne.NewSize(700, 0))
+	w.CenterOnScreen()
+	w.Show()
+}
+
+// setPorts runs on whichever goroutine changed the ports. fyne 2.2 has nothing like tview's QueueUpdateDraw,
+// its widgets are made to be updated from anywhere through their methods, so the options are swapped
+// in one go and the label lookups go through the locked PortList.
+func (ui *UI) setPorts() {
+	labels, selected := ui.ports.Update(ui.state)
+
+	ui.portList.Options = labels
+	if selected < 0 {
+		ui.portList.ClearSelected()
+	} else {
+		ui.portList.SetSelected(labels[selected])
+	}
+	ui.portList.Refresh()
+}
+
+func (ui *UI) setFlashing() {
+	if ui.state.Ready().NotFlashing {
+		ui.cancelBtn.Disable()
+		ui.portList.Enable()
+	} else {
+		ui.cancelBtn.Enable()
+		ui.portList.Disable()
+	}
+}
+
 func (ui *UI) setStatus(text string) {
 	ui.statusBar.SetText(text)
 }
@@ -278,12 +621,12 @@ func (ui *UI) resizeMainWindow() {
 
 func (ui *UI) showCustomSection() {
 	ui.customSection.Show()
-	ui.state.CustomSelected = true
+	ui.state.SetCustomSelected(true)
 	ui.resizeMainWindow()
 }
 
 func (ui *UI) hideCustomSection() {
 	ui.customSection.Hide()
-	ui.state.CustomSelected = false
+	ui.state.SetCustomSelected(false)
 	ui.resizeMainWindow()
 }
@@ -17,6 +17,19 @@ type CustomLayout struct {
 	NoseFuseJoin bool
 }
 
+// Schema describes how a firmware takes its custom layout
+type Schema struct {
+	// File is where the generated layout goes, relative to the sketch folder
+	File     string
+	Generate func(l *CustomLayout) []byte
+}
+
+// V1_SCHEMA is the layout.h of defines used by the original LEDController firmware
+var V1_SCHEMA = &Schema{
+	File:     "layout.h",
+	Generate: GenerateCustomLayout,
+}
+
 func DefaultLayout() *CustomLayout {
 	return &CustomLayout{
 		WingLEDs:    31,
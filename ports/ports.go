@@ -0,0 +1,153 @@
+package ports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/udev"
+)
+
+// Kind is how likely a port is to have an LED controller on it
+type Kind int
+
+const (
+	// KIND_OTHER is bluetooth, built in serial ports, network ports, etc
+	KIND_OTHER Kind = iota
+	KIND_USB_SERIAL
+	// KIND_CONTROLLER is a usb-serial chip the controllers are built with
+	KIND_CONTROLLER
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KIND_CONTROLLER:
+		return "LED controller"
+	case KIND_USB_SERIAL:
+		return "USB serial"
+	}
+	return "other"
+}
+
+// Info is a detected port along with whatever we could find out about the device on it
+type Info struct {
+	*rpc.Port
+
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string
+	Manufacturer string
+	// Board is the arduino board arduino-cli recognised from the usb ids, if any
+	Board string
+	Kind  Kind
+}
+
+// Describe pulls the usb details out of a detected port, and classifies it against the known
+// usb-serial chips plus any extra "vid:pid" ids
+func Describe(p *rpc.DetectedPort, ids []string) *Info {
+	info := &Info{Port: p.Port}
+	if p.Port == nil {
+		return info
+	}
+	props := p.Port.Properties
+
+	info.VID = normalizeID(props["vid"])
+	info.PID = normalizeID(props["pid"])
+	info.SerialNumber = props["serialNumber"]
+	for _, b := range p.MatchingBoards {
+		if b.Name != "" {
+			info.Board = b.Name
+			break
+		}
+	}
+	info.Manufacturer, info.Product = usbStrings(p.Port.Address)
+
+	if p.Port.Protocol == "serial" && info.VID != "" {
+		info.Kind = KIND_USB_SERIAL
+		if info.Chip() != "" {
+			info.Kind = KIND_CONTROLLER
+		}
+		for _, id := range ids {
+			if id == info.USBID() {
+				info.Kind = KIND_CONTROLLER
+			}
+		}
+	}
+	return info
+}
+
+// "0x1A86" -> "1a86"
+func normalizeID(id string) string {
+	return strings.TrimPrefix(strings.ToLower(id), "0x")
+}
+
+// USBID is the "vid:pid" pair, or "" for non-usb ports
+func (i *Info) USBID() string {
+	if i.VID == "" {
+		return ""
+	}
+	return i.VID + ":" + i.PID
+}
+
+// DeviceID identifies the physical device on a port, so it can be found again if it comes back at another address.
+// Boards without a usb serial number (most CH340s) can only be told apart by their vid:pid. "" for non-usb ports.
+func (i *Info) DeviceID() string {
+	if i.SerialNumber != "" {
+		return i.USBID() + "/" + i.SerialNumber
+	}
+	return i.USBID()
+}
+
+// Chip is the name of the known usb-serial chip on the port, if it is one
+func (i *Info) Chip() string {
+	for _, d := range udev.DEVICES {
+		if d.VID == i.VID && d.PID == i.PID {
+			return d.Name
+		}
+	}
+	return ""
+}
+
+// Label is a friendly name for the port, e.g. "CH340 USB serial (/dev/ttyUSB0)"
+func (i *Info) Label() string {
+	if i.Port == nil {
+		return ""
+	}
+
+	var name string
+	switch {
+	// the chips' own product strings are mostly just "USB Serial"
+	case i.Chip() != "":
+		name = i.Chip() + " USB serial"
+	case i.Product != "" && i.Manufacturer != "" && !strings.HasPrefix(i.Product, i.Manufacturer):
+		name = i.Manufacturer + " " + i.Product
+	case i.Product != "":
+		name = i.Product
+	case i.Board != "":
+		name = i.Board
+	case i.VID != "":
+		name = "USB serial " + i.USBID()
+	case i.ProtocolLabel != "":
+		name = i.ProtocolLabel
+	default:
+		return i.Address
+	}
+	return fmt.Sprintf("%s (%s)", name, i.Address)
+}
+
+// Sorted lists ports with the likely controllers first, then by address
+func Sorted(ports map[string]*Info) []*Info {
+	o := make([]*Info, 0, len(ports))
+	for _, p := range ports {
+		o = append(o, p)
+	}
+	sort.Slice(o, func(a, b int) bool {
+		if o[a].Kind != o[b].Kind {
+			return o[a].Kind > o[b].Kind
+		}
+		return o[a].Address < o[b].Address
+	})
+	return o
+}
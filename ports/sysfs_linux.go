@@ -0,0 +1,31 @@
+package ports
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// usbStrings reads the manufacturer and product strings of the usb device behind a tty out of sysfs
+func usbStrings(addr string) (string, string) {
+	dev, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", filepath.Base(addr), "device"))
+	if err != nil {
+		return "", ""
+	}
+
+	// the tty hangs off a usb interface, the strings live on the usb device a level or two up
+	for dir := dev; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return readAttr(dir, "manufacturer"), readAttr(dir, "product")
+		}
+	}
+	return "", ""
+}
+
+func readAttr(dir string, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
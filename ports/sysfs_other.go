@@ -0,0 +1,8 @@
+//go:build !linux
+
+package ports
+
+// only linux hands out the usb strings without cgo
+func usbStrings(addr string) (string, string) {
+	return "", ""
+}
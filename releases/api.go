@@ -0,0 +1,160 @@
+package releases
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reyemxela/LEDControllerUpdater/config"
+)
+
+// API_TIMEOUT is how long a single api request or small file fetch gets before giving up
+const API_TIMEOUT = 30 * time.Second
+
+// CacheDir is where api responses are kept for conditional requests. Empty disables the cache.
+var CacheDir = ""
+
+var apiClient = &http.Client{Timeout: API_TIMEOUT}
+
+// RateLimitError is returned when the github api refuses us until Reset
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Reset.IsZero() {
+		return "github api rate limit reached, try again later"
+	}
+	return "github api rate limit reached, resets at " + e.Reset.Local().Format(time.Kitchen)
+}
+
+type cachedResponse struct {
+	ETag string `json:"etag"`
+	Next string `json:"next"`
+	Body []byte `json:"body"`
+}
+
+var linkNextRe = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// fetchAPI gets one page of an api response, along with the url of the next page (if any),
+// going by the Link header or gitlab's X-Next-Page. Responses are cached by ETag, so unchanged pages don't count against github's rate limit.
+func fetchAPI(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// only ever hand the github token to github
+	if strings.HasPrefix(url, GITHUB_API_URL) {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token := config.Get().GithubToken; token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	cached, cacheFile := loadCached(url)
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached.Body, cached.Next, nil
+	case isRateLimited(resp):
+		return nil, "", rateLimitError(resp)
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if m := linkNextRe.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	} else if page := resp.Header.Get("X-Next-Page"); page != "" {
+		next = nextPageURL(url, page)
+	}
+
+	if cacheFile != "" && resp.Header.Get("ETag") != "" {
+		saveCached(cacheFile, &cachedResponse{ETag: resp.Header.Get("ETag"), Next: next, Body: body})
+	}
+
+	return body, next, nil
+}
+
+// nextPageURL swaps the page query parameter in rawURL for page
+func nextPageURL(rawURL string, page string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("page", page)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+func rateLimitError(resp *http.Response) error {
+	e := &RateLimitError{}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		e.Reset = time.Unix(reset, 0)
+	} else if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.Reset = time.Now().Add(time.Duration(wait) * time.Second)
+	}
+	return e
+}
+
+func loadCached(url string) (*cachedResponse, string) {
+	if CacheDir == "" {
+		return nil, ""
+	}
+
+	sum := sha1.Sum([]byte(url))
+	cacheFile := filepath.Join(CacheDir, hex.EncodeToString(sum[:])+".json")
+
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return nil, cacheFile
+	}
+	cached := &cachedResponse{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, cacheFile
+	}
+	return cached, cacheFile
+}
+
+func saveCached(cacheFile string, cached *cachedResponse) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(filepath.Dir(cacheFile), 0777)
+	os.WriteFile(cacheFile, data, 0666)
+}
@@ -0,0 +1,128 @@
+package releases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/reyemxela/LEDControllerUpdater/config"
+)
+
+func withCacheDir(t *testing.T) {
+	t.Helper()
+	old := CacheDir
+	CacheDir = t.TempDir()
+	t.Cleanup(func() { CacheDir = old })
+}
+
+func TestFetchAPINotModified(t *testing.T) {
+	withCacheDir(t)
+	const etag = `"abc"`
+	requests, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(`[{"tag_name": "v1.0.0"}]`))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		body, _, err := fetchAPI(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != `[{"tag_name": "v1.0.0"}]` {
+			t.Errorf("request %d: body = %q", i, body)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("%d requests, %d not modified, want 2 and 1", requests, notModified)
+	}
+}
+
+func TestFetchAPIRateLimit(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset.Unix()))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, _, err := fetchAPI(context.Background(), srv.URL)
+	var rl *RateLimitError
+	if !errors.As(err, &rl) {
+		t.Fatalf("got %v, want a RateLimitError", err)
+	}
+	if !rl.Reset.Equal(reset) {
+		t.Errorf("reset = %s, want %s", rl.Reset, reset)
+	}
+}
+
+func TestParseReleasesLinkPagination(t *testing.T) {
+	const pages = 3
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < pages {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/releases?page=%d>; rel="next", <%s/releases?page=%d>; rel="last"`, srv.URL, page+1, srv.URL, pages))
+		}
+		fmt.Fprintf(w, `[{"tag_name": "v1.%d.0"}]`, page)
+	}))
+	defer srv.Close()
+
+	releases, err := ParseReleases(context.Background(), srv.URL+"/releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != pages {
+		t.Fatalf("got %d releases, want %d", len(releases), pages)
+	}
+	for i, r := range releases {
+		if want := fmt.Sprintf("v1.%d.0", i+1); r.TagName != want {
+			t.Errorf("release %d = %s, want %s", i, r.TagName, want)
+		}
+	}
+}
+
+func TestFetchAPITokenOnlyForGithub(t *testing.T) {
+	t.Setenv(config.ENV_GITHUB_TOKEN, "secret")
+	if config.Get().GithubToken != "secret" {
+		t.Fatal("token not picked up from the environment")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("token sent to %s: %q", r.Host, auth)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	if _, _, err := fetchAPI(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchAPICancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := fetchAPI(ctx, srv.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
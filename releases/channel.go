@@ -0,0 +1,46 @@
+package releases
+
+import (
+	"github.com/reyemxela/LEDControllerUpdater/semver"
+)
+
+// Channel picks which kinds of releases are offered
+type Channel string
+
+const (
+	CHANNEL_STABLE Channel = "stable" // normal releases only
+	CHANNEL_BETA   Channel = "beta"   // stable plus pre-releases
+	CHANNEL_ALL    Channel = "all"    // everything, including drafts (only visible with a token)
+)
+
+var CHANNELS = []Channel{CHANNEL_STABLE, CHANNEL_BETA, CHANNEL_ALL}
+
+// ParseChannel falls back to stable for anything it doesn't recognize
+func ParseChannel(name string) Channel {
+	for _, c := range CHANNELS {
+		if string(c) == name {
+			return c
+		}
+	}
+	return CHANNEL_STABLE
+}
+
+// IsPrerelease checks the release's own flag, and also its tag, since not everyone ticks the box
+func (r SourceRelease) IsPrerelease() bool {
+	if r.Prerelease {
+		return true
+	}
+	v, err := semver.Parse(r.Tag)
+	return err == nil && v.IsPrerelease()
+}
+
+// Includes reports whether a release belongs on this channel
+func (c Channel) Includes(r SourceRelease) bool {
+	switch c {
+	case CHANNEL_ALL:
+		return true
+	case CHANNEL_BETA:
+		return !r.Draft
+	}
+	return !r.Draft && !r.IsPrerelease()
+}
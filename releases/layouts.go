@@ -0,0 +1,38 @@
+package releases
+
+import (
+	"strings"
+
+	"github.com/reyemxela/LEDControllerUpdater/semver"
+)
+
+// DisplayName turns the asset's layout name into something friendlier, "radian_xl" -> "Radian Xl"
+func (a Asset) DisplayName() string {
+	name := a.Layout
+	if name == "" {
+		name = strings.TrimSuffix(a.Name, ".hex")
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	})
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// LayoutHistory groups layouts by display name across every version, listing the versions
+// each one is available in for the given board, newest first
+func (v Versions) LayoutHistory(board string) map[string][]string {
+	history := make(map[string][]string)
+	for ver, r := range v {
+		for name := range r.LayoutsFor(board) {
+			history[name] = append(history[name], ver)
+		}
+	}
+	for _, vers := range history {
+		semver.Sort(vers)
+	}
+	return history
+}
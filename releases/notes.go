@@ -0,0 +1,57 @@
+package releases
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/reyemxela/LEDControllerUpdater/semver"
+)
+
+// Heading is the release's name and publish date, as a markdown heading
+func (r *Release) Heading() string {
+	h := "### " + r.Name
+	if r.Prerelease {
+		h += " (pre-release)"
+	}
+	if !r.Published.IsZero() {
+		h += " - " + r.Published.Format("2006-01-02")
+	}
+	return h
+}
+
+// NotesMarkdown is the release's notes under its heading
+func (r *Release) NotesMarkdown() string {
+	notes := strings.TrimSpace(r.Notes)
+	if notes == "" {
+		notes = "_No release notes._"
+	}
+	return r.Heading() + "\n\n" + notes + "\n"
+}
+
+// NotesSince collects the notes of every release after from, up to and including to, newest first.
+// If from isn't older than to, it's just to's notes.
+func (v Versions) NotesSince(from string, to string) string {
+	target := v[to]
+	if target == nil {
+		return ""
+	}
+	if from == "" || from == to || !semver.Newer(to, from) {
+		return target.NotesMarkdown()
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		if semver.Newer(name, from) && !semver.Newer(name, to) {
+			names = append(names, name)
+		}
+	}
+	semver.Sort(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Changes since %s\n\n", from)
+	for _, name := range names {
+		b.WriteString(v[name].NotesMarkdown())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -1,66 +1,230 @@
 package releases
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
-)
+	"time"
 
-const (
-	API_URL = "https://api.github.com/repos/wingnut-tech/LEDController/releases"
+	"github.com/reyemxela/LEDControllerUpdater/utils"
+	"github.com/reyemxela/LEDControllerUpdater/verify"
 )
 
-// Layouts is a map of filename:url ({"radian_v1.x.x": "https://..."})
-type Layouts map[string]string
+// CHECKSUM_FILES are the asset names a release's sha256 checksums file can go by
+var CHECKSUM_FILES = []string{"SHA256SUMS", "SHA256SUMS.txt", "sha256sums.txt", "checksums.txt"}
+
+// Asset is a single downloadable file from a release
+type Asset struct {
+	Name   string
+	URL    string
+	SHA256 string
+
+	AssetInfo
+}
+
+// AssetInfo is what can be worked out from a hex's filename
+type AssetInfo struct {
+	Layout  string // aircraft/layout name, "radian"
+	Version string // firmware version the hex was built from, "v1.2.0"
+	Board   string // board variant the hex is built for, empty if it isn't board specific
+}
+
+// AssetMatcher decides whether an asset is a firmware hex, and parses its filename
+type AssetMatcher func(name string) (info AssetInfo, ok bool)
+
+// Layouts is a map of filename:asset ({"radian_v1.x.x.hex": Asset{...}})
+type Layouts map[string]Asset
 
-// Versions is a map of vernum:layouts ({"v1.x.x": Layouts(radian, ...)})
-type Versions map[string]Layouts
+// LayoutChoices is a map of display name:asset ({"Radian": Asset{...}}), for picking from
+type LayoutChoices map[string]Asset
+
+// Release is everything we know about a single firmware version
+type Release struct {
+	Name      string
+	Tag       string
+	SourceURL string
+	Layouts   Layouts
+
+	Prerelease bool
+	Published  time.Time
+	Notes      string // release notes, usually markdown
+
+	ChecksumsURL string
+	SignatureURL string
+
+	checksums map[string]string
+}
+
+// Versions is a map of vernum:release ({"v1.x.x": Release{Layouts(radian, ...)}})
+type Versions map[string]*Release
 
 // GithubReleases is a generic container for any github release info
 type GithubReleases []struct {
-	Name   string `json:"name"`
-	Assets []struct {
-		Name string `json:"name"`
-		URL  string `json:"browser_download_url"`
+	Name        string    `json:"name"`
+	TagName     string    `json:"tag_name"`
+	Prerelease  bool      `json:"prerelease"`
+	Draft       bool      `json:"draft"`
+	PublishedAt time.Time `json:"published_at"`
+	Body        string    `json:"body"`
+	Assets      []struct {
+		Name   string `json:"name"`
+		URL    string `json:"browser_download_url"`
+		Digest string `json:"digest"`
 	} `json:"assets"`
 }
 
-func ParseReleases(url string) (GithubReleases, error) {
-	resp, err := http.Get(url)
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	if path, ok := utils.LocalPath(url); ok {
+		return os.ReadFile(path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
 
+// ParseReleases fetches every page of a github-style releases api
+func ParseReleases(ctx context.Context, url string) (GithubReleases, error) {
 	releases := GithubReleases{}
-	err = json.Unmarshal(body, &releases)
-	if err != nil {
-		return nil, err
+
+	for url != "" {
+		body, next, err := fetchAPI(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		page := GithubReleases{}
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, page...)
+		url = next
 	}
 
 	return releases, nil
 }
 
-func GetVersions() (Versions, error) {
+// GetVersions lists the releases from src that are on the given channel
+func GetVersions(ctx context.Context, src ReleaseSource, match AssetMatcher, channel Channel) (Versions, error) {
 	versions := make(Versions)
-	releases, err := ParseReleases(API_URL)
+	releases, err := src.Releases(ctx)
 	if err != nil || len(releases) < 1 {
 		return versions, err
 	}
 
 	for _, release := range releases {
-		versions[release.Name] = Layouts{}
+		if !channel.Includes(release) {
+			continue
+		}
+		if release.Name == "" {
+			release.Name = release.Tag
+		}
+		r := &Release{
+			Name:      release.Name,
+			Tag:       release.Tag,
+			SourceURL: release.SourceURL,
+			Layouts:   Layouts{},
+
+			Prerelease: release.IsPrerelease(),
+			Published:  release.Published,
+			Notes:      release.Notes,
+		}
+		for _, asset := range release.Assets {
+			if info, ok := match(asset.Name); ok {
+				r.Layouts[asset.Name] = Asset{
+					Name:      asset.Name,
+					URL:       asset.URL,
+					SHA256:    verify.NormalizeSum(asset.SHA256),
+					AssetInfo: info,
+				}
+			}
+		}
+		checksumsName := ""
+		for _, asset := range release.Assets {
+			for _, c := range CHECKSUM_FILES {
+				if strings.EqualFold(asset.Name, c) {
+					checksumsName = asset.Name
+					r.ChecksumsURL = asset.URL
+				}
+			}
+		}
 		for _, asset := range release.Assets {
-			if strings.HasSuffix(asset.Name, ".hex") {
-				versions[release.Name][asset.Name] = asset.URL
+			if checksumsName != "" && asset.Name == checksumsName+".sig" {
+				r.SignatureURL = asset.URL
 			}
 		}
+		versions[release.Name] = r
 	}
 	return versions, nil
 }
+
+// LayoutsFor returns only the layouts that will run on the given board, by display name
+func (r *Release) LayoutsFor(board string) LayoutChoices {
+	layouts := LayoutChoices{}
+	for _, asset := range r.Layouts {
+		if asset.Board == "" || board == "" || asset.Board == board {
+			layouts[asset.DisplayName()] = asset
+		}
+	}
+	return layouts
+}
+
+// Checksum finds the expected sha256 of a file in this release, using the release's
+// checksums file when there is one, and falling back to github's asset digest.
+// An empty string means there's nothing to check against.
+func (r *Release) Checksum(ctx context.Context, name string) (string, error) {
+	if r.checksums == nil && r.ChecksumsURL != "" {
+		sums, err := r.fetchChecksums(ctx)
+		if err != nil {
+			return "", err
+		}
+		r.checksums = sums
+	}
+
+	if sum, ok := r.checksums[name]; ok {
+		return sum, nil
+	}
+	if verify.Required() {
+		return "", fmt.Errorf("%s: no signed checksum in release %s", name, r.Name)
+	}
+	return r.Layouts[name].SHA256, nil
+}
+
+func (r *Release) fetchChecksums(ctx context.Context) (map[string]string, error) {
+	data, err := fetch(ctx, r.ChecksumsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if verify.Required() {
+		if r.SignatureURL == "" {
+			return nil, fmt.Errorf("release %s checksums aren't signed", r.Name)
+		}
+		sig, err := fetch(ctx, r.SignatureURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := verify.CheckSignature(data, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	return verify.ParseChecksums(data), nil
+}
@@ -0,0 +1,350 @@
+package releases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reyemxela/LEDControllerUpdater/utils"
+)
+
+const (
+	DEFAULT_SOURCE = "github:wingnut-tech/LEDController"
+	GITHUB_API_URL = "https://api.github.com"
+	GITHUB_URL     = "https://github.com"
+	INDEX_FILE     = "index.json"
+)
+
+// SourceRelease is a single release as listed by a ReleaseSource
+type SourceRelease struct {
+	Name      string
+	Tag       string
+	SourceURL string // zip of the firmware source at this tag
+	Assets    []Asset
+
+	Prerelease bool
+	Draft      bool
+	Published  time.Time
+	Notes      string
+}
+
+// ReleaseSource is anywhere firmware releases can be listed and downloaded from
+type ReleaseSource interface {
+	// Releases lists every release, newest first
+	Releases(ctx context.Context) ([]SourceRelease, error)
+	// Host is the server releases are fetched from, "" for a local folder
+	Host() string
+	String() string
+}
+
+// ParseSource turns a source spec into a ReleaseSource. Specs look like:
+//
+//	github:owner/repo
+//	gitea:https://git.example.com/owner/repo
+//	gitlab:https://gitlab.com/group/project
+//	index:https://example.com/firmware/index.json
+//	index:/path/to/local/folder
+func ParseSource(spec string) (ReleaseSource, error) {
+	if spec == "" {
+		spec = DEFAULT_SOURCE
+	}
+
+	kind, loc, ok := strings.Cut(spec, ":")
+	if !ok || loc == "" {
+		return nil, fmt.Errorf("%s: invalid release source", spec)
+	}
+
+	switch kind {
+	case "github":
+		owner, repo, ok := strings.Cut(loc, "/")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected github:owner/repo", spec)
+		}
+		return &GitHub{Owner: owner, Repo: repo}, nil
+	case "gitea", "forgejo":
+		u, err := url.Parse(loc)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: expected gitea:https://host/owner/repo", spec)
+		}
+		u.Path = ""
+		return &Gitea{BaseURL: u.String(), Owner: parts[0], Repo: parts[1]}, nil
+	case "gitlab":
+		u, err := url.Parse(loc)
+		if err != nil {
+			return nil, err
+		}
+		project := strings.Trim(u.Path, "/")
+		if project == "" {
+			return nil, fmt.Errorf("%s: expected gitlab:https://host/group/project", spec)
+		}
+		u.Path = ""
+		return &GitLab{BaseURL: u.String(), Project: project}, nil
+	case "index":
+		return &Index{Location: loc}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown release source type %s", spec, kind)
+}
+
+// GitHub lists releases through the github api
+type GitHub struct {
+	Owner string
+	Repo  string
+}
+
+func (g *GitHub) Host() string {
+	return GITHUB_API_URL
+}
+
+func (g *GitHub) String() string {
+	return "github:" + g.Owner + "/" + g.Repo
+}
+
+func (g *GitHub) Releases(ctx context.Context) ([]SourceRelease, error) {
+	releases, err := ParseReleases(ctx, fmt.Sprintf("%s/repos/%s/%s/releases?per_page=100", GITHUB_API_URL, g.Owner, g.Repo))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SourceRelease, 0, len(releases))
+	for _, r := range releases {
+		sr := SourceRelease{
+			Name:      r.Name,
+			Tag:       r.TagName,
+			SourceURL: fmt.Sprintf("%s/%s/%s/archive/refs/tags/%s.zip", GITHUB_URL, g.Owner, g.Repo, r.TagName),
+
+			Prerelease: r.Prerelease,
+			Draft:      r.Draft,
+			Published:  r.PublishedAt,
+			Notes:      r.Body,
+		}
+		for _, a := range r.Assets {
+			sr.Assets = append(sr.Assets, Asset{Name: a.Name, URL: a.URL, SHA256: a.Digest})
+		}
+		out = append(out, sr)
+	}
+	return out, nil
+}
+
+// Gitea lists releases from a gitea or forgejo server, which mostly mimic the github api
+type Gitea struct {
+	BaseURL string
+	Owner   string
+	Repo    string
+}
+
+func (g *Gitea) Host() string {
+	return g.BaseURL
+}
+
+func (g *Gitea) String() string {
+	return "gitea:" + g.BaseURL + "/" + g.Owner + "/" + g.Repo
+}
+
+func (g *Gitea) Releases(ctx context.Context) ([]SourceRelease, error) {
+	// 50 is gitea's default max page size, the rest come through the Link header
+	releases, err := ParseReleases(ctx, fmt.Sprintf("%s/api/v1/repos/%s/%s/releases?limit=50", g.BaseURL, g.Owner, g.Repo))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SourceRelease, 0, len(releases))
+	for _, r := range releases {
+		sr := SourceRelease{
+			Name:      r.Name,
+			Tag:       r.TagName,
+			SourceURL: fmt.Sprintf("%s/%s/%s/archive/%s.zip", g.BaseURL, g.Owner, g.Repo, r.TagName),
+
+			Prerelease: r.Prerelease,
+			Draft:      r.Draft,
+			Published:  r.PublishedAt,
+			Notes:      r.Body,
+		}
+		for _, a := range r.Assets {
+			sr.Assets = append(sr.Assets, Asset{Name: a.Name, URL: a.URL})
+		}
+		out = append(out, sr)
+	}
+	return out, nil
+}
+
+// GitLab lists releases from gitlab.com or a self-hosted gitlab instance
+type GitLab struct {
+	BaseURL string
+	Project string // full path, "group/subgroup/project"
+}
+
+type gitlabReleases []struct {
+	Name        string    `json:"name"`
+	TagName     string    `json:"tag_name"`
+	Description string    `json:"description"`
+	ReleasedAt  time.Time `json:"released_at"`
+	Assets      struct {
+		Links []struct {
+			Name      string `json:"name"`
+			URL       string `json:"url"`
+			DirectURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func (g *GitLab) Host() string {
+	return g.BaseURL
+}
+
+func (g *GitLab) String() string {
+	return "gitlab:" + g.BaseURL + "/" + g.Project
+}
+
+func (g *GitLab) Releases(ctx context.Context) ([]SourceRelease, error) {
+	releases := gitlabReleases{}
+	next := fmt.Sprintf("%s/api/v4/projects/%s/releases?per_page=100", g.BaseURL, url.PathEscape(g.Project))
+	for next != "" {
+		body, nextPage, err := fetchAPI(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		page := gitlabReleases{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		releases = append(releases, page...)
+		next = nextPage
+	}
+
+	out := make([]SourceRelease, 0, len(releases))
+	for _, r := range releases {
+		sr := SourceRelease{
+			Name:      r.Name,
+			Tag:       r.TagName,
+			SourceURL: fmt.Sprintf("%s/%s/-/archive/%s/%s-%s.zip", g.BaseURL, g.Project, r.TagName, path.Base(g.Project), r.TagName),
+
+			Published: r.ReleasedAt,
+			Notes:     r.Description,
+		}
+		// gitlab has no pre-release flag, the tag says it instead. upcoming_release is only about the release date.
+		sr.Prerelease = sr.IsPrerelease()
+		for _, l := range r.Assets.Links {
+			u := l.DirectURL
+			if u == "" {
+				u = l.URL
+			}
+			sr.Assets = append(sr.Assets, Asset{Name: l.Name, URL: u})
+		}
+		out = append(out, sr)
+	}
+	return out, nil
+}
+
+// Index reads releases from a static json file, served over http or sitting in a local folder:
+//
+//	{"releases": [{"name": "v1.2.0", "tag": "v1.2.0", "source": "v1.2.0.zip", "prerelease": false, "draft": false,
+//	  "published": "2022-09-01T00:00:00Z", "notes": "markdown release notes",
+//	  "assets": [{"name": "radian_v1.2.0.hex", "url": "v1.2.0/radian_v1.2.0.hex", "sha256": "..."}]}]}
+//
+// Relative urls are resolved against the index file's location.
+type Index struct {
+	Location string
+}
+
+type indexFile struct {
+	Releases []struct {
+		Name       string    `json:"name"`
+		Tag        string    `json:"tag"`
+		Source     string    `json:"source"`
+		Prerelease bool      `json:"prerelease"`
+		Draft      bool      `json:"draft"`
+		Published  time.Time `json:"published"`
+		Notes      string    `json:"notes"`
+		Assets     []struct {
+			Name   string `json:"name"`
+			URL    string `json:"url"`
+			SHA256 string `json:"sha256"`
+		} `json:"assets"`
+	} `json:"releases"`
+}
+
+func (i *Index) Host() string {
+	u, err := i.indexURL()
+	if err != nil || u.Scheme == "file" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (i *Index) String() string {
+	return "index:" + i.Location
+}
+
+// indexURL works out the full url of the index file, turning local paths into file:// urls
+func (i *Index) indexURL() (*url.URL, error) {
+	if strings.HasPrefix(i.Location, "http://") || strings.HasPrefix(i.Location, "https://") {
+		return url.Parse(i.Location)
+	}
+
+	p, err := filepath.Abs(strings.TrimPrefix(i.Location, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		p = filepath.Join(p, INDEX_FILE)
+	}
+	return utils.FileURL(p), nil
+}
+
+func (i *Index) Releases(ctx context.Context) ([]SourceRelease, error) {
+	base, err := i.indexURL()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := fetch(ctx, base.String())
+	if err != nil {
+		return nil, err
+	}
+
+	index := indexFile{}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, err
+	}
+
+	resolve := func(ref string) string {
+		if ref == "" {
+			return ""
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	out := make([]SourceRelease, 0, len(index.Releases))
+	for _, r := range index.Releases {
+		sr := SourceRelease{
+			Name:      r.Name,
+			Tag:       r.Tag,
+			SourceURL: resolve(r.Source),
+
+			Prerelease: r.Prerelease,
+			Draft:      r.Draft,
+			Published:  r.Published,
+			Notes:      r.Notes,
+		}
+		for _, a := range r.Assets {
+			sr.Assets = append(sr.Assets, Asset{Name: a.Name, URL: resolve(a.URL), SHA256: a.SHA256})
+		}
+		out = append(out, sr)
+	}
+	return out, nil
+}
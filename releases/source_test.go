@@ -0,0 +1,150 @@
+package releases
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitLabPagination(t *testing.T) {
+	const pages = 3
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/group/project/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < pages {
+			w.Header().Set("X-Next-Page", fmt.Sprint(page+1))
+		}
+		fmt.Fprintf(w, `[{"name": "v1.%d.0", "tag_name": "v1.%d.0"}]`, page, page)
+	}))
+	defer srv.Close()
+
+	src, err := ParseSource("gitlab:" + srv.URL + "/group/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := src.Releases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != pages {
+		t.Fatalf("got %d releases, want %d", len(releases), pages)
+	}
+	for i, r := range releases {
+		if want := fmt.Sprintf("v1.%d.0", i+1); r.Tag != want {
+			t.Errorf("release %d = %s, want %s", i, r.Tag, want)
+		}
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	got := nextPageURL("https://gitlab.com/api/v4/projects/a%2Fb/releases?per_page=100&page=2", "3")
+	if want := "https://gitlab.com/api/v4/projects/a%2Fb/releases?page=3&per_page=100"; got != want {
+		t.Errorf("nextPageURL = %s, want %s", got, want)
+	}
+}
+
+func TestSourceHost(t *testing.T) {
+	for spec, want := range map[string]string{
+		"github:owner/repo":                             GITHUB_API_URL,
+		"gitea:https://git.example.com/owner/repo":      "https://git.example.com",
+		"gitlab:https://gitlab.example.com/group/proj":  "https://gitlab.example.com",
+		"index:https://example.com/firmware/index.json": "https://example.com",
+		"index:" + t.TempDir():                          "",
+	} {
+		src, err := ParseSource(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := src.Host(); got != want {
+			t.Errorf("%s: Host = %q, want %q", spec, got, want)
+		}
+	}
+}
+
+func TestGitLabPrerelease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an upcoming release is only scheduled for later, it's not a pre-release
+		fmt.Fprint(w, `[{"tag_name": "v1.1.0-beta.1"}, {"tag_name": "v1.0.0", "upcoming_release": true}]`)
+	}))
+	defer srv.Close()
+
+	src, err := ParseSource("gitlab:" + srv.URL + "/group/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := src.Releases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range releases {
+		if want := r.Tag == "v1.1.0-beta.1"; r.Prerelease != want {
+			t.Errorf("%s prerelease = %v, want %v", r.Tag, r.Prerelease, want)
+		}
+	}
+}
+
+func TestGiteaPagination(t *testing.T) {
+	const pages = 2
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") == "" {
+			t.Error("no page size asked for")
+		}
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < pages {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?limit=50&page=%d>; rel="next"`, srv.URL, r.URL.Path, page+1))
+		}
+		fmt.Fprintf(w, `[{"tag_name": "v1.%d.0"}]`, page)
+	}))
+	defer srv.Close()
+
+	src, err := ParseSource("gitea:" + srv.URL + "/owner/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := src.Releases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != pages {
+		t.Errorf("got %d releases, want %d", len(releases), pages)
+	}
+}
+
+func TestIndexDrafts(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"releases": [
+		{"tag": "v1.0.0", "assets": [{"name": "radian_v1.0.0.hex", "url": "radian_v1.0.0.hex"}]},
+		{"tag": "v1.1.0", "draft": true, "assets": [{"name": "radian_v1.1.0.hex", "url": "radian_v1.1.0.hex"}]}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, INDEX_FILE), []byte(index), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ParseSource("index:" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := func(name string) (AssetInfo, bool) { return AssetInfo{Layout: "radian"}, true }
+	for _, tt := range []struct {
+		channel Channel
+		want    int
+	}{{CHANNEL_STABLE, 1}, {CHANNEL_BETA, 1}, {CHANNEL_ALL, 2}} {
+		versions, err := GetVersions(context.Background(), src, match, tt.channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != tt.want {
+			t.Errorf("%s: got %d versions, want %d", tt.channel, len(versions), tt.want)
+		}
+	}
+}
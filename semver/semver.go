@@ -0,0 +1,151 @@
+package semver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version, "v1.2.3-beta.1+build"
+type Version struct {
+	Major, Minor, Patch int
+
+	// Pre holds the dot separated pre-release identifiers, empty for a normal release
+	Pre []string
+
+	Build string
+}
+
+// Parse reads a version, with or without a leading "v". Missing minor/patch numbers count as 0, so "v1.2" is fine.
+func Parse(s string) (Version, error) {
+	v := Version{}
+
+	str := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), "V")
+	str, v.Build, _ = strings.Cut(str, "+")
+	str, pre, hasPre := strings.Cut(str, "-")
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("%s: not a version", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%s: not a version", s)
+		}
+		*nums[i] = n
+	}
+
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("%s: empty pre-release", s)
+		}
+		v.Pre = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// IsPrerelease reports whether this is a "-alpha", "-rc.1" etc. version
+func (v Version) IsPrerelease() bool {
+	return len(v.Pre) > 0
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as, or newer than o. Build metadata is ignored.
+func (v Version) Compare(o Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// a pre-release comes before the release itself
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePre(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(v.Pre), len(o.Pre))
+}
+
+// comparePre compares single pre-release identifiers: numbers numerically, and below any text
+func comparePre(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInt(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare compares two version strings. Anything that isn't a version sorts below every real version,
+// and gets compared as plain text against other non-versions.
+func Compare(a, b string) int {
+	av, aErr := Parse(a)
+	bv, bErr := Parse(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		if c := av.Compare(bv); c != 0 {
+			return c
+		}
+		// keep the order stable for "1.0" vs "v1.0.0"
+		return strings.Compare(a, b)
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// Newer reports whether a is a newer version than b
+func Newer(a, b string) bool {
+	return Compare(a, b) > 0
+}
+
+// Sort orders version strings newest first
+func Sort(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return Compare(versions[i], versions[j]) > 0
+	})
+}
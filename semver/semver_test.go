@@ -0,0 +1,145 @@
+package semver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "v1.2.3", want: "v1.2.3"},
+		{in: "1.2.3", want: "v1.2.3"},
+		{in: "V1.2", want: "v1.2.0"},
+		{in: "v2", want: "v2.0.0"},
+		{in: " v1.2.3 ", want: "v1.2.3"},
+		{in: "v1.2.3-beta.2", want: "v1.2.3-beta.2"},
+		{in: "v1.2.3-rc.1+build.5", want: "v1.2.3-rc.1+build.5"},
+		{in: "", err: true},
+		{in: "latest", err: true},
+		{in: "v1.2.3.4", err: true},
+		{in: "v1..3", err: true},
+		{in: "v1.-2.3", err: true},
+		{in: "v1.2.x", err: true},
+		{in: "v1.2.3-", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := Parse(tt.in)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("Parse(%q) = %s, want an error", tt.in, v)
+		case !tt.err && err != nil:
+			t.Errorf("Parse(%q): %s", tt.in, err)
+		case !tt.err && v.String() != tt.want:
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, v, tt.want)
+		}
+	}
+}
+
+func TestIsPrerelease(t *testing.T) {
+	for in, want := range map[string]bool{
+		"v1.0.0":        false,
+		"v1.0.0+build":  false,
+		"v1.0.0-beta":   true,
+		"v1.0.0-rc.1+x": true,
+	} {
+		v, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.IsPrerelease() != want {
+			t.Errorf("%s IsPrerelease = %v", in, !want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.9.0", "v1.10.0", -1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.0.1", "v1.0.0", 1},
+		{"v1.0.0", "v1.0.0", 0},
+		{"v1.0.0+a", "v1.0.0+b", 0},
+
+		// pre-releases
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-beta.11", "v1.0.0-rc.1", -1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0", "v1.0.0-rc.1", 1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-1", "v1.0.0-alpha", -1},
+		{"v1.0.1-alpha", "v1.0.0", 1},
+	}
+
+	for _, tt := range tests {
+		a, err := Parse(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareStrings(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.10.0", "v1.9.0", 1},
+		{"v0.0.1", "nightly", 1},
+		{"nightly", "v0.0.1", -1},
+		{"alpha", "beta", -1},
+		{"nightly", "nightly", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if !Newer("v1.10.0", "v1.9.0") || Newer("v1.9.0", "v1.10.0") {
+		t.Error("Newer got v1.9.0 and v1.10.0 the wrong way round")
+	}
+}
+
+func TestSort(t *testing.T) {
+	versions := []string{
+		"v1.0.0-beta.11",
+		"nightly",
+		"v1.9.0",
+		"v1.0.0",
+		"v1.0.0-rc.1",
+		"custom",
+		"v1.10.0",
+		"v1.0.0-beta.2",
+	}
+	Sort(versions)
+
+	want := []string{
+		"v1.10.0",
+		"v1.9.0",
+		"v1.0.0",
+		"v1.0.0-rc.1",
+		"v1.0.0-beta.11",
+		"v1.0.0-beta.2",
+		"nightly",
+		"custom",
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("Sort =\n%v\nwant\n%v", versions, want)
+	}
+}
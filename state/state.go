@@ -1,13 +1,21 @@
 package state
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/arduino/arduino-cli/cli/instance"
+	"github.com/arduino/arduino-cli/commands"
 	"github.com/arduino/arduino-cli/configuration"
 	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/cache"
+	"github.com/reyemxela/LEDControllerUpdater/config"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
 	"github.com/reyemxela/LEDControllerUpdater/layout"
+	"github.com/reyemxela/LEDControllerUpdater/ports"
 	"github.com/reyemxela/LEDControllerUpdater/releases"
 	"github.com/sirupsen/logrus"
 )
@@ -16,26 +24,56 @@ const (
 	APP_NAME     = "LED Controller Updater"
 	APP_VERSION  = "v1.2.0"
 	TMP_DIR_NAME = "LEDControllerUpdater"
+
+	ARDUINO_CONFIG_FILE = "arduino-cli.yaml"
 )
 
+// Change tells subscribers which part of the State was modified
+type Change int
+
+const (
+	VersionsChanged Change = iota
+	FirmwareChanged
+	BoardChanged
+	SelectionChanged
+	CustomLayoutChanged
+	PortsChanged
+	ReadyChanged
+)
+
+// State is shared between the port watcher, the flashing goroutine and the frontends,
+// so everything mutable lives behind the mutex and is only reachable through methods.
 type State struct {
-	Instance *rpc.Instance
-	Ready    Ready
-	TmpDir   string
+	mu sync.RWMutex
 
-	Versions       releases.Versions
-	CurrentVersion string
-	CurrentLayout  string
+	instance *rpc.Instance
+	ready    Ready
 
-	CustomLayout   *layout.CustomLayout
-	CustomSelected bool
+	firmware *firmware.Profile
+	board    *firmware.Board
 
-	Ports       map[string]*rpc.Port
-	CurrentPort string
+	versions       releases.Versions
+	currentVersion string
+	currentLayout  string
 
-	StatusFunc func(text string)
+	customLayout   layout.CustomLayout
+	customSelected bool
+
+	ports       map[string]*ports.Info
+	currentPort string
+	// wantedDevice is the ports.Info.DeviceID of the port the user picked, to find it again after a replug
+	wantedDevice string
 
-	AppType string
+	cancelOperation context.CancelFunc
+
+	buildSize *firmware.SizeReport
+
+	subscribers []func(Change)
+
+	// these are set once in NewState and never change
+	TmpDir     string
+	AppType    string
+	StatusFunc func(text string)
 }
 
 type Ready struct {
@@ -51,46 +89,419 @@ func NewState(appType string, statusFunc func(text string)) (*State, error) {
 	s.AppType = appType
 	s.StatusFunc = statusFunc
 
-	// arduino-cli config
-	configuration.Settings = configuration.Init("")
+	// a broken config file shouldn't stop the app from starting, it just means defaults
+	config.Load()
+
+	// arduino-cli gets its own directories, so we don't fight with an arduino ide install over core and library versions
+	arduinoDir, err := config.ArduinoDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(arduinoDir, 0777); err != nil {
+		return nil, fmt.Errorf("arduino data dir: %w", err)
+	}
+	configuration.Settings = configuration.Init(filepath.Join(arduinoDir, ARDUINO_CONFIG_FILE))
+	configuration.Settings.Set("directories.Data", arduinoDir)
+	configuration.Settings.Set("directories.Downloads", filepath.Join(arduinoDir, "staging"))
+	configuration.Settings.Set("directories.User", filepath.Join(arduinoDir, "user"))
 	logrus.SetLevel(logrus.FatalLevel)
-	s.Instance = instance.CreateAndInit()
+	s.instance = instance.CreateAndInit()
 
-	s.CustomLayout = layout.DefaultLayout()
-	s.CustomSelected = false
+	s.firmware = firmware.Get(config.Get().Firmware)
+	s.board = s.firmware.Boards[0]
 
-	tmpDir := os.TempDir()
-	if tmpDir != "" {
-		tmpDir = filepath.Join(tmpDir, TMP_DIR_NAME)
-		os.MkdirAll(tmpDir, 0777)
-		os.Chdir(tmpDir)
+	s.customLayout = *layout.DefaultLayout()
+	s.customSelected = false
+
+	// always a folder of our own, the cache gets pruned and that mustn't ever be the whole temp dir
+	tmpDir := filepath.Join(os.TempDir(), TMP_DIR_NAME)
+	if config.Portable() {
+		// keep the cache on the stick too, there's no telling what the temp dir is on the next machine
+		dir, err := config.Dir()
+		if err != nil {
+			return nil, fmt.Errorf("portable data dir: %w", err)
+		}
+		tmpDir = filepath.Join(dir, config.CACHE_DIR_NAME)
 	}
+	os.MkdirAll(tmpDir, 0777)
 	s.TmpDir = tmpDir
+	releases.CacheDir = filepath.Join(tmpDir, cache.API_DIR)
 
-	s.Ports = make(map[string]*rpc.Port)
+	s.ports = make(map[string]*ports.Info)
 
-	s.Ready = Ready{
+	s.ready = Ready{
 		NotFlashing: true,
 	}
 
 	return s, nil
 }
 
+// Subscribe registers fn to be called after every change.
+// It runs on whichever goroutine made the change, so frontends need to hop over to their UI thread if required.
+func (s *State) Subscribe(fn func(Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+func (s *State) notify(c Change) {
+	s.mu.RLock()
+	subs := make([]func(Change), len(s.subscribers))
+	copy(subs, s.subscribers)
+	s.mu.RUnlock()
+
+	for _, fn := range subs {
+		fn(c)
+	}
+}
+
 func (s *State) SetStatus(text string) {
 	if s.StatusFunc != nil {
 		s.StatusFunc(text)
 	}
 }
 
+func (s *State) Instance() *rpc.Instance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instance
+}
+
+// ReloadInstance replaces the arduino-cli instance after its files changed underneath it (bundle imports, repairs).
+// The old one is destroyed first, so its package and library managers don't linger pointing at the old tree,
+// and nothing can get hold of it in between.
+func (s *State) ReloadInstance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.instance != nil {
+		commands.Destroy(context.Background(), &rpc.DestroyRequest{Instance: s.instance})
+	}
+	s.instance = instance.CreateAndInit()
+}
+
+func (s *State) Ready() Ready {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ready
+}
+
+// UpdateReady changes the ready flags through fn while holding the lock
+func (s *State) UpdateReady(fn func(r *Ready)) {
+	s.mu.Lock()
+	fn(&s.ready)
+	s.mu.Unlock()
+	s.notify(ReadyChanged)
+}
+
+// StartFlashing marks the state as flashing, returning false if a flash is already running
+func (s *State) StartFlashing() bool {
+	s.mu.Lock()
+	if !s.ready.NotFlashing {
+		s.mu.Unlock()
+		return false
+	}
+	s.ready.NotFlashing = false
+	s.mu.Unlock()
+	s.notify(ReadyChanged)
+	return true
+}
+
+func (s *State) FinishFlashing() {
+	s.mu.Lock()
+	s.ready.NotFlashing = true
+	portChanged := s.checkCurrentPort()
+	s.mu.Unlock()
+	s.notify(ReadyChanged)
+	if portChanged {
+		s.notify(PortsChanged)
+	}
+}
+
+func (s *State) Firmware() *firmware.Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.firmware
+}
+
+// SetFirmware switches to another firmware profile. The old versions are dropped, since they belong to the old firmware.
+func (s *State) SetFirmware(name string) {
+	s.mu.Lock()
+	s.firmware = firmware.Get(name)
+	s.board = s.firmware.Board(s.board.Name)
+	s.versions = releases.Versions{}
+	s.currentVersion = ""
+	s.currentLayout = ""
+	s.mu.Unlock()
+	s.notify(FirmwareChanged)
+	s.notify(VersionsChanged)
+}
+
+func (s *State) Board() *firmware.Board {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.board
+}
+
+func (s *State) SetBoard(name string) {
+	s.mu.Lock()
+	board := s.firmware.Board(name)
+	if board == s.board {
+		s.mu.Unlock()
+		return
+	}
+	s.board = board
+	s.mu.Unlock()
+	s.notify(BoardChanged)
+}
+
+func (s *State) Versions() releases.Versions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versions
+}
+
+func (s *State) SetVersions(v releases.Versions) {
+	s.mu.Lock()
+	s.versions = v
+	s.mu.Unlock()
+	s.notify(VersionsChanged)
+}
+
+// Release returns the currently selected version's release info, or nil
+func (s *State) Release() *releases.Release {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versions[s.currentVersion]
+}
+
+// Layouts returns the current version's layouts that fit the selected board, by display name
+func (s *State) Layouts() releases.LayoutChoices {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	release := s.versions[s.currentVersion]
+	if release == nil {
+		return releases.LayoutChoices{}
+	}
+	return release.LayoutsFor(s.board.Name)
+}
+
+func (s *State) CurrentVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentVersion
+}
+
+func (s *State) SetCurrentVersion(ver string) {
+	s.mu.Lock()
+	s.currentVersion = ver
+	s.mu.Unlock()
+	s.notify(SelectionChanged)
+}
+
+func (s *State) CurrentLayout() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentLayout
+}
+
+func (s *State) SetCurrentLayout(lay string) {
+	s.mu.Lock()
+	s.currentLayout = lay
+	s.mu.Unlock()
+	s.notify(SelectionChanged)
+}
+
+func (s *State) CustomSelected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.customSelected
+}
+
+func (s *State) SetCustomSelected(selected bool) {
+	s.mu.Lock()
+	s.customSelected = selected
+	s.mu.Unlock()
+	s.notify(SelectionChanged)
+}
+
+// CustomLayout returns a copy of the custom layout, safe to use while the UI keeps editing it
+func (s *State) CustomLayout() layout.CustomLayout {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.customLayout
+}
+
+func (s *State) UpdateCustomLayout(fn func(l *layout.CustomLayout)) {
+	s.mu.Lock()
+	fn(&s.customLayout)
+	s.mu.Unlock()
+	s.notify(CustomLayoutChanged)
+}
+
+// Ports returns a copy of the known ports, keyed by address
+func (s *State) Ports() map[string]*ports.Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o := make(map[string]*ports.Info, len(s.ports))
+	for k, v := range s.ports {
+		o[k] = v
+	}
+	return o
+}
+
+func (s *State) CurrentPort() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentPort
+}
+
+// Port returns the currently selected port, or nil
+func (s *State) Port() *rpc.Port {
+	if info := s.PortInfo(); info != nil {
+		return info.Port
+	}
+	return nil
+}
+
+// PortInfo returns the details of the currently selected port, or nil
+func (s *State) PortInfo() *ports.Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ports[s.currentPort]
+}
+
+// SetCurrentPort selects a port, and remembers the device on it so the selection can follow it to another
+// address if it gets replugged. The selection can't change in the middle of a flash.
+func (s *State) SetCurrentPort(addr string) {
+	s.mu.Lock()
+	if _, ok := s.ports[addr]; !ok {
+		addr = ""
+	}
+	// frontends echo the selection back when redrawing their port lists, don't loop on it
+	if addr == s.currentPort {
+		s.mu.Unlock()
+		return
+	}
+	// the port can't change mid flash, but the frontend already shows the new one, so have it redraw
+	if !s.ready.NotFlashing {
+		s.mu.Unlock()
+		s.notify(PortsChanged)
+		return
+	}
+	s.currentPort = addr
+	s.wantedDevice = ""
+	if addr != "" {
+		s.wantedDevice = s.ports[addr].DeviceID()
+	}
+	s.ready.PortSelected = addr != ""
+	s.mu.Unlock()
+	s.notify(PortsChanged)
+}
+
+// AddPort adds a newly plugged in port. It only gets selected if nothing else is, and it's either the device
+// that was selected before it got unplugged, or (when there's no such device) any usb serial port.
+// Bluetooth and built in ports are never picked automatically.
+func (s *State) AddPort(port *ports.Info) {
+	s.mu.Lock()
+	s.ports[port.Address] = port
+	if s.currentPort == "" && s.ready.NotFlashing {
+		if (s.wantedDevice != "" && port.DeviceID() == s.wantedDevice) || (s.wantedDevice == "" && port.Kind != ports.KIND_OTHER) {
+			s.currentPort = port.Address
+			s.wantedDevice = port.DeviceID()
+			s.ready.PortSelected = true
+		}
+	}
+	s.mu.Unlock()
+	s.notify(PortsChanged)
+}
+
+// RefreshPorts tells subscribers to redraw the port list without anything changing, e.g. after a display setting changed
+func (s *State) RefreshPorts() {
+	s.notify(PortsChanged)
+}
+
+// RemovePort drops an unplugged port. If it was the current one, nothing is selected until the same device
+// comes back or the user picks another, rather than jumping to some unrelated port.
+// During a flash the selection is left alone, FinishFlashing sorts it out afterwards.
+func (s *State) RemovePort(addr string) {
+	s.mu.Lock()
+	delete(s.ports, addr)
+	if s.currentPort == addr && s.ready.NotFlashing {
+		s.currentPort = ""
+		s.ready.PortSelected = false
+	}
+	s.mu.Unlock()
+	s.notify(PortsChanged)
+}
+
+// checkCurrentPort drops the selection if its port went away during a flash, picking up the same device
+// if it's already back at another address. The caller must hold the lock.
+func (s *State) checkCurrentPort() bool {
+	if _, ok := s.ports[s.currentPort]; ok || s.currentPort == "" {
+		return false
+	}
+
+	s.currentPort = ""
+	if s.wantedDevice != "" {
+		// only when there's no doubt which one it is, two boards without serial numbers look the same
+		matches := []string{}
+		for addr, p := range s.ports {
+			if p.DeviceID() == s.wantedDevice {
+				matches = append(matches, addr)
+			}
+		}
+		if len(matches) == 1 {
+			s.currentPort = matches[0]
+		}
+	}
+	s.ready.PortSelected = s.currentPort != ""
+	return true
+}
+
+// BuildSize is the memory usage of the last custom build, or nil
+func (s *State) BuildSize() *firmware.SizeReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.buildSize
+}
+
+func (s *State) SetBuildSize(size *firmware.SizeReport) {
+	s.mu.Lock()
+	s.buildSize = size
+	s.mu.Unlock()
+}
+
+// NewOperation returns a context for a long-running operation (flashing, etc.) that CancelOperation can abort
+func (s *State) NewOperation() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancelOperation = cancel
+	s.mu.Unlock()
+	return ctx
+}
+
+func (s *State) CancelOperation() {
+	s.mu.RLock()
+	cancel := s.cancelOperation
+	s.mu.RUnlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
 func (s *State) CheckReady() bool {
+	r := s.Ready()
 	switch {
-	case !s.Ready.PortSelected:
+	case !r.PortSelected:
 		s.SetStatus("No port selected")
-	case !s.Ready.CoreInstalled:
+	case !r.CoreInstalled:
 		s.SetStatus("Arduino core still installing")
-	case !s.Ready.LibrariesInstalled:
+	case !r.LibrariesInstalled:
 		s.SetStatus("Arduino libraries still installing")
-	case !s.Ready.NotFlashing:
+	case !r.NotFlashing:
 	default:
 		return true
 	}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/reyemxela/LEDControllerUpdater/firmware"
+	"github.com/reyemxela/LEDControllerUpdater/layout"
+	"github.com/reyemxela/LEDControllerUpdater/ports"
+)
+
+// newTestState is NewState without arduino-cli or the config file
+func newTestState() *State {
+	s := &State{
+		ports:        make(map[string]*ports.Info),
+		ready:        Ready{NotFlashing: true},
+		customLayout: *layout.DefaultLayout(),
+		StatusFunc:   func(string) {},
+	}
+	s.firmware = firmware.Get("")
+	s.board = s.firmware.Boards[0]
+	return s
+}
+
+func usbPort(addr string, serial string) *ports.Info {
+	return &ports.Info{
+		Port:         &rpc.Port{Address: addr, Protocol: "serial"},
+		VID:          "1a86",
+		PID:          "7523",
+		SerialNumber: serial,
+		Kind:         ports.KIND_CONTROLLER,
+	}
+}
+
+// TestConcurrentPortsAndFlashing is the port watcher, a flash and the frontends all going at once.
+// It's mostly here for go test -race.
+func TestConcurrentPortsAndFlashing(t *testing.T) {
+	s := newTestState()
+
+	var mu sync.Mutex
+	seen := map[Change]int{}
+	s.Subscribe(func(c Change) {
+		// frontends read state back from inside their callbacks
+		s.Ports()
+		s.Port()
+		s.Ready()
+		mu.Lock()
+		seen[c]++
+		mu.Unlock()
+	})
+
+	const rounds = 200
+	var wg sync.WaitGroup
+
+	// port watcher
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			addr := fmt.Sprintf("/dev/ttyUSB%d", i%4)
+			s.AddPort(usbPort(addr, fmt.Sprint(i%4)))
+			if i%3 == 0 {
+				s.RemovePort(addr)
+			}
+		}
+	}()
+
+	// flashing
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if !s.StartFlashing() {
+				continue
+			}
+			ctx := s.NewOperation()
+			if port := s.Port(); port != nil {
+				_ = port.Address
+			}
+			s.SetBuildSize(&firmware.SizeReport{Flash: int64(i)})
+			_ = ctx.Err()
+			s.CancelOperation()
+			s.FinishFlashing()
+		}
+	}()
+
+	// a user clicking around
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			s.SetCurrentPort(fmt.Sprintf("/dev/ttyUSB%d", i%4))
+			s.CheckReady()
+			s.BuildSize()
+			s.RefreshPorts()
+		}
+	}()
+
+	wg.Wait()
+
+	if !s.Ready().NotFlashing {
+		t.Error("still flashing after every flash finished")
+	}
+	if cur := s.CurrentPort(); cur != "" {
+		if _, ok := s.Ports()[cur]; !ok {
+			t.Errorf("current port %s isn't a known port", cur)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if seen[PortsChanged] == 0 || seen[ReadyChanged] == 0 {
+		t.Errorf("subscribers missed changes: %v", seen)
+	}
+}
+
+func TestSetCurrentPortWhileFlashing(t *testing.T) {
+	s := newTestState()
+	s.AddPort(usbPort("/dev/ttyUSB0", "a"))
+	s.AddPort(usbPort("/dev/ttyUSB1", "b"))
+	s.SetCurrentPort("/dev/ttyUSB0")
+
+	if !s.StartFlashing() {
+		t.Fatal("couldn't start flashing")
+	}
+	changes := 0
+	s.Subscribe(func(c Change) {
+		if c == PortsChanged {
+			changes++
+		}
+	})
+
+	s.SetCurrentPort("/dev/ttyUSB1")
+	if cur := s.CurrentPort(); cur != "/dev/ttyUSB0" {
+		t.Errorf("port changed to %s mid flash", cur)
+	}
+	// frontends have to hear about it to put their selection back
+	if changes != 1 {
+		t.Errorf("got %d PortsChanged, want 1", changes)
+	}
+
+	// and the redraw echoing the real port back doesn't loop
+	s.SetCurrentPort("/dev/ttyUSB0")
+	if changes != 1 {
+		t.Errorf("got %d PortsChanged after the echo, want 1", changes)
+	}
+
+	s.FinishFlashing()
+	s.SetCurrentPort("/dev/ttyUSB1")
+	if cur := s.CurrentPort(); cur != "/dev/ttyUSB1" {
+		t.Errorf("port = %s after flashing, want /dev/ttyUSB1", cur)
+	}
+}
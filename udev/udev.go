@@ -0,0 +1,49 @@
+package udev
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RULES_FILE is where the rule gets installed. It has to sort before 73-seat-late.rules, which is what
+// turns the uaccess tag into permissions for the logged in user.
+const RULES_FILE = "/etc/udev/rules.d/70-ledcontroller.rules"
+
+// Device is a usb serial chip found on the nanos and clones the controllers use
+type Device struct {
+	Name string
+	VID  string
+	PID  string
+}
+
+var DEVICES = []Device{
+	{Name: "CH340", VID: "1a86", PID: "7523"},
+	{Name: "CH341", VID: "1a86", PID: "5523"},
+	{Name: "FTDI FT232R", VID: "0403", PID: "6001"},
+	{Name: "FTDI FT231X", VID: "0403", PID: "6015"},
+}
+
+// Rules is the udev rule file giving whoever is logged in at the machine read/write access to the given devices.
+// Everyone else still needs to be in the device's group, as usual.
+func Rules(devices []Device) string {
+	b := &strings.Builder{}
+	b.WriteString("# serial access for LED controllers, installed by LED Controller Updater\n")
+	for _, d := range devices {
+		fmt.Fprintf(b, "# %s\n", d.Name)
+		fmt.Fprintf(b, "SUBSYSTEM==\"tty\", ATTRS{idVendor}==\"%s\", ATTRS{idProduct}==\"%s\", MODE=\"0660\", TAG+=\"uaccess\"\n", d.VID, d.PID)
+	}
+	return b.String()
+}
+
+// InstallScript is the shell script (to be run as root) that writes the rules from its stdin into place
+// and gets udev to apply them to anything already plugged in.
+// The rules never touch a file anyone else could swap out before root copies it.
+func InstallScript() string {
+	tmp := RULES_FILE + ".tmp"
+	return fmt.Sprintf("umask 022 && cat > %s && mv %s %s && udevadm control --reload-rules && udevadm trigger --subsystem-match=tty",
+		quote(tmp), quote(tmp), quote(RULES_FILE))
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,51 @@
+package udev
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	got := Rules([]Device{
+		{Name: "CH340", VID: "1a86", PID: "7523"},
+		{Name: "FTDI FT232R", VID: "0403", PID: "6001"},
+	})
+	want := `# serial access for LED controllers, installed by LED Controller Updater
+# CH340
+SUBSYSTEM=="tty", ATTRS{idVendor}=="1a86", ATTRS{idProduct}=="7523", MODE="0660", TAG+="uaccess"
+# FTDI FT232R
+SUBSYSTEM=="tty", ATTRS{idVendor}=="0403", ATTRS{idProduct}=="6001", MODE="0660", TAG+="uaccess"
+`
+	if got != want {
+		t.Errorf("Rules() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRulesCoverKnownChips(t *testing.T) {
+	rules := Rules(DEVICES)
+	for _, id := range []string{"1a86\", ATTRS{idProduct}==\"7523", "0403\", ATTRS{idProduct}==\"6001"} {
+		if !strings.Contains(rules, id) {
+			t.Errorf("rules missing %s", id)
+		}
+	}
+	if strings.Contains(rules, "0666") || strings.Contains(rules, "RUN") {
+		t.Error("rules should only grant access to the logged in user")
+	}
+}
+
+func TestInstallScript(t *testing.T) {
+	script := InstallScript()
+	if !strings.Contains(script, "cat > '"+RULES_FILE+".tmp'") || !strings.Contains(script, "mv '"+RULES_FILE+".tmp' '"+RULES_FILE+"'") {
+		t.Errorf("script doesn't write %s from stdin: %s", RULES_FILE, script)
+	}
+	// uaccess only works from rules that run before 73-seat-late.rules
+	if name := RULES_FILE[strings.LastIndex(RULES_FILE, "/")+1:]; name >= "73-seat-late.rules" {
+		t.Errorf("%s sorts after 73-seat-late.rules", name)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("it's"); got != `'it'\''s'` {
+		t.Errorf("quote = %s", got)
+	}
+}
@@ -1,103 +1,287 @@
 package update
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/reyemxela/LEDControllerUpdater/archive"
+	"github.com/reyemxela/LEDControllerUpdater/config"
 	"github.com/reyemxela/LEDControllerUpdater/releases"
+	"github.com/reyemxela/LEDControllerUpdater/semver"
 	"github.com/reyemxela/LEDControllerUpdater/state"
-	"github.com/reyemxela/LEDControllerUpdater/utils"
+	"github.com/reyemxela/LEDControllerUpdater/verify"
 )
 
 const (
-	APP_API_URL            = "https://api.github.com/repos/reyemxela/LEDControllerUpdater/releases"
-	APP_RELEASE_URL_PREFIX = "https://github.com/reyemxela/LEDControllerUpdater/releases"
+	// HEALTH_CHECK_TIMEOUT is how long a new version gets to answer -version before we give up on it
+	HEALTH_CHECK_TIMEOUT = 15 * time.Second
+
+	// LAUNCH_CHECK_TIME is how long the new version has to keep running after we start it before we trust it
+	LAUNCH_CHECK_TIME = 3 * time.Second
+
+	// STAGE_PREFIX names the temporary folders new versions get unpacked into, next to the app
+	STAGE_PREFIX = ".update-"
 )
 
+// ARCHIVE_EXTS are the archive types app releases can come in
+var ARCHIVE_EXTS = []string{".tar.gz", ".zip"}
+
+// APP_SOURCE is where new versions of the app itself are released
+var APP_SOURCE = &releases.GitHub{Owner: "reyemxela", Repo: "LEDControllerUpdater"}
+
 func CheckForUpdate(s *state.State) (bool, string) {
-	releases, err := releases.ParseReleases(APP_API_URL)
-	if err != nil || len(releases) < 1 {
+	all, err := APP_SOURCE.Releases(context.Background())
+	if err != nil {
 		return false, ""
 	}
 
-	latest := releases[0].Name
-	if latest > state.APP_VERSION {
+	// don't trust the api's ordering, pick the highest version on our channel
+	channel := releases.ParseChannel(config.Get().Channel)
+	latest := ""
+	for _, r := range all {
+		if channel.Includes(r) && (latest == "" || semver.Newer(r.Name, latest)) {
+			latest = r.Name
+		}
+	}
+	if latest != "" && semver.Newer(latest, state.APP_VERSION) {
 		return true, latest
 	}
 	return false, ""
 }
 
+// UpdateApp downloads and verifies version ver, checks that it actually runs, then swaps it in for the running app.
+// Anything that goes wrong before the new version is started leaves the current one in place.
 func UpdateApp(ver string, s *state.State, onSuccess func()) error {
 	en, err := GetBinName()
 	if err != nil {
 		return err
 	}
-	edir := filepath.Dir(en)
+	target := installTarget(en)
+
+	candidates, err := archiveNames(s.AppType)
+	if err != nil {
+		return err
+	}
+
+	s.SetStatus("Downloading " + ver + "...")
+	archiveFile, err := downloadRelease(ver, candidates, s.TmpDir)
+	if err != nil {
+		return err
+	}
+
+	s.SetStatus("Checking " + ver + "...")
+	stageDir, err := os.MkdirTemp(filepath.Dir(target), STAGE_PREFIX)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+
+	staged, err := stage(archiveFile, stageDir, target, filepath.Base(en))
+	if err != nil {
+		return err
+	}
+
+	// the binary lives at the same spot inside the new bundle as the old one
+	stagedBin := staged
+	if target != en {
+		rel, err := filepath.Rel(target, en)
+		if err != nil {
+			return err
+		}
+		stagedBin = filepath.Join(staged, rel)
+	}
+	if err := healthCheck(stagedBin, ver); err != nil {
+		return err
+	}
+
+	s.SetStatus("Installing " + ver + "...")
+	bakPath := target + ".bak"
+	if err := swap(staged, target, bakPath); err != nil {
+		return err
+	}
+
+	if err := launch(en); err != nil {
+		rollback(target, bakPath)
+		return fmt.Errorf("couldn't start %s, rolled back: %w", ver, err)
+	}
+	if onSuccess != nil {
+		onSuccess()
+	}
+	// deferred calls don't run on exit
+	os.RemoveAll(stageDir)
+	os.Exit(0)
+
+	return nil
+}
+
+// installTarget is what gets replaced on update: the binary itself, or on mac, the whole .app bundle around it
+func installTarget(en string) string {
+	if runtime.GOOS != "darwin" {
+		return en
+	}
+	for dir := filepath.Dir(en); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if strings.HasSuffix(dir, ".app") {
+			return dir
+		}
+	}
+	return en
+}
+
+// launch starts the new version and gives it LAUNCH_CHECK_TIME to crash. The binary gets started directly even
+// inside a mac .app, since "open" returns straight away whatever the app does.
+func launch(en string) error {
+	cmd := exec.Command(en)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
 
-	zipPrefix := "LEDControllerUpdater"
-	switch s.AppType {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("new version quit: %w", err)
+		}
+	case <-time.After(LAUNCH_CHECK_TIME):
+	}
+	return nil
+}
+
+// archiveNames lists the release asset names this app could be in, most likely first
+func archiveNames(appType string) ([]string, error) {
+	prefix := "LEDControllerUpdater"
+	switch appType {
 	case "CLI":
-		zipPrefix += "CLI"
+		prefix += "CLI"
 	case "GUI":
-		if runtime.GOOS == "darwin" {
-			return fmt.Errorf("unable to update GUI mac apps")
-		}
 	default:
-		return fmt.Errorf("unknown app type")
+		return nil, fmt.Errorf("unknown app type")
 	}
 
-	var zipName string
+	var suffix string
 	switch runtime.GOOS {
 	case "linux":
-		zipName = zipPrefix + "_linux.zip"
+		suffix = "_linux"
 	case "windows":
-		zipName = zipPrefix + "_windows.zip"
+		suffix = "_windows"
 	case "darwin":
-		zipName = zipPrefix + "_mac.zip"
+		suffix = "_mac"
 	default:
-		return fmt.Errorf("unsupported OS")
+		return nil, fmt.Errorf("unsupported OS")
 	}
 
-	s.SetStatus("Updating app...")
+	names := []string{}
+	for _, ext := range ARCHIVE_EXTS {
+		names = append(names, prefix+suffix+ext)
+	}
+	return names, nil
+}
 
-	url := fmt.Sprintf("%s/%s/%s/%s", APP_RELEASE_URL_PREFIX, "download", ver, zipName)
-	zipFile := filepath.Join(s.TmpDir, zipName)
-	err = utils.DownloadFile(zipFile, url)
+// downloadRelease fetches whichever of the candidate assets the release has into dir, checked against the
+// release's checksums, and returns its path
+func downloadRelease(ver string, candidates []string, dir string) (string, error) {
+	match := func(asset string) (releases.AssetInfo, bool) {
+		for _, c := range candidates {
+			if asset == c {
+				return releases.AssetInfo{Version: ver}, true
+			}
+		}
+		return releases.AssetInfo{}, false
+	}
+	versions, err := releases.GetVersions(context.Background(), APP_SOURCE, match, releases.CHANNEL_ALL)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	bakPath := en + ".bak"
-	err = os.Rename(en, bakPath)
-	if err != nil {
-		return err
+	release := versions[ver]
+	if release == nil {
+		return "", fmt.Errorf("release %s not found", ver)
+	}
+
+	for _, name := range candidates {
+		asset, ok := release.Layouts[name]
+		if !ok {
+			continue
+		}
+		sum, err := release.Checksum(context.Background(), name)
+		if err != nil {
+			return "", err
+		}
+		filename := filepath.Join(dir, ver+"_"+name)
+		return filename, verify.Download(context.Background(), filename, asset.URL, sum)
 	}
+	return "", fmt.Errorf("release %s has nothing for this system", ver)
+}
 
-	fileNames, err := utils.UnzipFile(zipFile, edir)
+// stage extracts the archive into dir (next to the target, so the final rename can't cross filesystems)
+// and returns the new app bundle, or the binary called binName
+func stage(archiveFile string, dir string, target string, binName string) (string, error) {
+	res, err := archive.Extract(archiveFile, dir, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if fileNames[0] != en {
-		err = os.Rename(fileNames[0], en)
-		if err != nil {
-			return err
+	if strings.HasSuffix(target, ".app") {
+		if !strings.HasSuffix(res.Root, ".app") {
+			return "", fmt.Errorf("%s: no .app in archive", filepath.Base(archiveFile))
 		}
+		return res.Root, nil
 	}
 
-	cmd := exec.Command(en)
-	cmd.Start()
-	if onSuccess != nil {
-		onSuccess()
+	for _, f := range res.Files {
+		if filepath.Base(f) != binName {
+			continue
+		}
+		if info, err := os.Lstat(f); err == nil && info.Mode().IsRegular() {
+			return f, os.Chmod(f, 0755)
+		}
 	}
-	os.Exit(0)
+	return "", fmt.Errorf("%s: no %s in archive", filepath.Base(archiveFile), binName)
+}
+
+// healthCheck makes sure the staged binary runs on this machine and is the version we asked for
+func healthCheck(bin string, ver string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, bin, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("new version failed to run: %w", err)
+	}
+	got := strings.TrimSpace(string(out))
+	if semver.Compare(got, ver) != 0 && got != ver {
+		return fmt.Errorf("new version reports %q, expected %s", got, ver)
+	}
+	return nil
+}
 
+// swap moves the current binary or bundle to bakPath and the staged one into its place, undoing the first step if the second fails
+func swap(staged string, target string, bakPath string) error {
+	os.RemoveAll(bakPath)
+	if err := os.Rename(target, bakPath); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, target); err != nil {
+		rollback(target, bakPath)
+		return err
+	}
 	return nil
 }
 
+func rollback(target string, bakPath string) {
+	os.RemoveAll(target)
+	os.Rename(bakPath, target)
+}
+
 func GetBinName() (string, error) {
 	en, err := os.Executable()
 	if err != nil {
@@ -118,12 +302,17 @@ func CleanOldVersions(s *state.State) {
 		return
 	}
 
+	target := installTarget(en)
 	bakPaths := []string{
-		en + ".bak",
+		target + ".bak",
 		filepath.Join(s.TmpDir, filepath.Base(en+".bak")),
 	}
 
+	// and anything an update didn't get to clean up
+	stages, _ := filepath.Glob(filepath.Join(filepath.Dir(target), STAGE_PREFIX+"*"))
+	bakPaths = append(bakPaths, stages...)
+
 	for _, p := range bakPaths {
-		os.Remove(p)
+		os.RemoveAll(p)
 	}
 }
@@ -0,0 +1,124 @@
+package update
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/reyemxela/LEDControllerUpdater/archive"
+)
+
+const BIN_NAME = "LEDControllerUpdater"
+
+// fixtures are in testdata, each as both a .zip and a .tar.gz
+var fixtureExts = []string{".zip", ".tar.gz"}
+
+func TestStage(t *testing.T) {
+	tests := []struct {
+		fixture string
+		target  string
+		// want is where the staged binary or bundle should end up, relative to the stage dir
+		want string
+		// root is what archive.Extract should report as the single top level directory, "" for the stage dir
+		root string
+	}{
+		{fixture: "bare", target: BIN_NAME, want: BIN_NAME},
+		{fixture: "root", target: BIN_NAME, want: filepath.Join(BIN_NAME, BIN_NAME), root: BIN_NAME},
+		{fixture: "app", target: BIN_NAME + ".app", want: BIN_NAME + ".app", root: BIN_NAME + ".app"},
+	}
+
+	for _, tt := range tests {
+		for _, ext := range fixtureExts {
+			tt, ext := tt, ext
+			t.Run(tt.fixture+ext, func(t *testing.T) {
+				fixture := filepath.Join("testdata", tt.fixture+ext)
+				dir := t.TempDir()
+				target := filepath.Join(t.TempDir(), tt.target)
+
+				staged, err := stage(fixture, dir, target, BIN_NAME)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := filepath.Join(dir, tt.want); staged != want {
+					t.Errorf("staged = %s, want %s", staged, want)
+				}
+
+				bin := staged
+				if filepath.Ext(target) == ".app" {
+					bin = filepath.Join(staged, "Contents", "MacOS", BIN_NAME)
+					if _, err := os.Stat(filepath.Join(staged, "Contents", "Info.plist")); err != nil {
+						t.Error(err)
+					}
+				}
+				info, err := os.Stat(bin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if runtime.GOOS != "windows" && info.Mode().Perm()&0100 == 0 {
+					t.Errorf("%s isn't executable", bin)
+				}
+
+				// and the root dir is picked out the same way when extracting on its own
+				extractDir := t.TempDir()
+				res, err := archive.Extract(fixture, extractDir, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := filepath.Join(extractDir, tt.root); res.Root != want {
+					t.Errorf("root = %s, want %s", res.Root, want)
+				}
+			})
+		}
+	}
+}
+
+func TestStageWrongArchive(t *testing.T) {
+	for _, ext := range fixtureExts {
+		// a plain binary where a .app was expected, and a binary with the wrong name
+		if _, err := stage(filepath.Join("testdata", "bare"+ext), t.TempDir(), filepath.Join(t.TempDir(), BIN_NAME+".app"), BIN_NAME); err == nil {
+			t.Errorf("bare%s staged as a .app", ext)
+		}
+		if _, err := stage(filepath.Join("testdata", "bare"+ext), t.TempDir(), filepath.Join(t.TempDir(), BIN_NAME), "other"); err == nil {
+			t.Errorf("bare%s staged without the right binary", ext)
+		}
+	}
+}
+
+func TestStageRejectsEscapes(t *testing.T) {
+	for _, fixture := range []string{"traversal", "symlink_chain"} {
+		for _, ext := range fixtureExts {
+			t.Run(fixture+ext, func(t *testing.T) {
+				parent := t.TempDir()
+				dir := filepath.Join(parent, "stage")
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := stage(filepath.Join("testdata", fixture+ext), dir, filepath.Join(t.TempDir(), BIN_NAME), BIN_NAME); err == nil {
+					t.Error("expected an error")
+				}
+				if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+					t.Error("file was written outside the stage dir")
+				}
+			})
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fixture binaries are shell scripts")
+	}
+
+	staged, err := stage(filepath.Join("testdata", "bare.tar.gz"), t.TempDir(), filepath.Join(t.TempDir(), BIN_NAME), BIN_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := healthCheck(staged, "v9.9.9"); err != nil {
+		t.Error(err)
+	}
+	if err := healthCheck(staged, "v1.0.0"); err == nil {
+		t.Error("wrong version passed the health check")
+	}
+}
@@ -1,14 +1,18 @@
 package utils
 
 import (
-	"archive/zip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/reyemxela/LEDControllerUpdater/semver"
 )
 
 func ListKeys[K string, V any](m map[K]V) []K {
@@ -23,77 +27,243 @@ func ListKeys[K string, V any](m map[K]V) []K {
 	return o
 }
 
-func DownloadFile(filename string, url string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("download: " + err.Error())
-	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("error downloading file")
+// ListVersions lists the keys of a map of versions, newest first
+func ListVersions[V any](m map[string]V) []string {
+	o := make([]string, 0, len(m))
+	for k := range m {
+		o = append(o, k)
 	}
+	semver.Sort(o)
 
-	defer resp.Body.Close()
+	return o
+}
 
-	out, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("download: " + err.Error())
-	}
-	defer out.Close()
+// Downloader fetches files over http, retrying failed attempts and resuming partial downloads
+type Downloader struct {
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("download: " + err.Error())
-	}
+// DefaultDownloader is what DownloadFile uses. Frontends can tweak its settings.
+var DefaultDownloader = &Downloader{
+	Client: &http.Client{
+		Timeout:   10 * time.Minute,
+		Transport: newTransport(),
+	},
+	Retries: 3,
+	Backoff: 2 * time.Second,
+}
 
-	return nil
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return t
 }
 
-func UnzipFile(filename string, dest string) ([]string, error) {
-	fileNames := []string{}
+// DownloadError keeps the url and http status of a failed download
+type DownloadError struct {
+	URL        string
+	StatusCode int
+	Err        error
 
-	r, err := zip.OpenReader(filename)
-	if err != nil {
-		return fileNames, err
+	// local is a problem on our end (disk full, permissions), which another try won't fix
+	local bool
+}
+
+func (e *DownloadError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("download %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
 	}
+	return fmt.Sprintf("download %s: %s", e.URL, e.Err.Error())
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// only server-side and network problems are worth another try
+func (e *DownloadError) retryable() bool {
+	switch {
+	case e.local:
+		return false
+	case e.StatusCode == 0:
+		return true
+	case e.StatusCode >= 500, e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusRequestTimeout,
+		e.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return true
+	}
+	return false
+}
+
+func DownloadFile(filename string, url string) error {
+	return DefaultDownloader.Download(context.Background(), filename, url)
+}
 
-	defer r.Close()
+func DownloadFileContext(ctx context.Context, filename string, url string) error {
+	return DefaultDownloader.Download(ctx, filename, url)
+}
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
+// Download saves url to filename. Data goes into a ".part" file first and is only
+// renamed into place once complete, so an interrupted download never leaves a broken file behind.
+// The .part file's ETag or Last-Modified is kept next to it, and it's only resumed if the file hasn't changed since.
+func (d *Downloader) Download(ctx context.Context, filename string, url string) error {
+	partFile := filename + ".part"
 
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fileNames, fmt.Errorf("%s: illegal filepath", fpath)
+	if path, ok := LocalPath(url); ok {
+		if err := copyFile(path, partFile); err != nil {
+			return &DownloadError{URL: url, Err: err, local: true}
 		}
+		return os.Rename(partFile, filename)
+	}
 
-		fileNames = append(fileNames, fpath)
+	for attempt := 0; ; attempt++ {
+		err := d.attempt(ctx, partFile, url)
+		if err == nil {
+			os.Remove(partFile + ".validator")
+			return os.Rename(partFile, filename)
+		}
+		if attempt >= d.Retries || ctx.Err() != nil || !err.retryable() {
+			return err
+		}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
+		// back off a bit more after every failure
+		select {
+		case <-ctx.Done():
+			return &DownloadError{URL: url, Err: ctx.Err()}
+		case <-time.After(d.Backoff * time.Duration(1<<attempt)):
 		}
+	}
+}
 
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return fileNames, err
+func (d *Downloader) attempt(ctx context.Context, partFile string, url string) *DownloadError {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return &DownloadError{URL: url, Err: err}
+	}
+
+	// pick up where the last attempt left off, but only if the file on the server is still the same one.
+	// If-Range makes the server send the whole thing instead when it's changed.
+	validatorFile := partFile + ".validator"
+	var offset int64
+	if info, err := os.Stat(partFile); err == nil {
+		validator, _ := os.ReadFile(validatorFile)
+		if len(validator) > 0 {
+			offset = info.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", string(validator))
 		}
+	}
 
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return fileNames, err
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return &DownloadError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// a fresh start, either nothing to resume or the server's copy changed
+		flags |= os.O_TRUNC
+		if err := saveValidator(validatorFile, resp.Header); err != nil {
+			return &DownloadError{URL: url, Err: err, local: true}
 		}
+	case resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is bad somehow, toss it and let the retry start fresh
+		os.Remove(partFile)
+		os.Remove(validatorFile)
+		return &DownloadError{URL: url, StatusCode: http.StatusRequestedRangeNotSatisfiable, Err: fmt.Errorf("bad resume range")}
+	default:
+		return &DownloadError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	out, err := os.OpenFile(partFile, flags, 0666)
+	if err != nil {
+		return &DownloadError{URL: url, Err: err, local: true}
+	}
+	defer out.Close()
 
-		rc, err := f.Open()
+	// io.Copy can't say which side failed, and only the network side is worth retrying
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return &DownloadError{URL: url, Err: err, local: true}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return fileNames, err
+			return &DownloadError{URL: url, Err: err}
 		}
+	}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+	if err := out.Close(); err != nil {
+		return &DownloadError{URL: url, Err: err, local: true}
+	}
+	return nil
+}
 
-		if err != nil {
-			return fileNames, err
+// saveValidator keeps whatever can tell later if the file changed. Weak ETags aren't allowed in If-Range,
+// and with nothing usable the next attempt just starts over.
+func saveValidator(validatorFile string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		if err := os.Remove(validatorFile); err != nil && !os.IsNotExist(err) {
+			return err
 		}
+		return nil
+	}
+	return os.WriteFile(validatorFile, []byte(validator), 0666)
+}
+
+// FileURL turns a local path into a file:// url
+func FileURL(path string) *neturl.URL {
+	p := filepath.ToSlash(path)
+	// windows drive letters need a leading slash to end up in the path part of the url
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return &neturl.URL{Scheme: "file", Path: p}
+}
+
+// LocalPath returns the local path of a file:// url
+func LocalPath(url string) (string, bool) {
+	u, err := neturl.Parse(url)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	p := u.Path
+	if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
 	}
+	return filepath.FromSlash(p), true
+}
 
-	return fileNames, nil
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveContent answers range requests the way a normal file server does, If-Range included
+func serveContent(etag *string, body *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", *etag)
+		http.ServeContent(w, r, "fw.hex", time.Time{}, strings.NewReader(*body))
+	}
+}
+
+func newTestDownloader() *Downloader {
+	return &Downloader{Client: &http.Client{}, Retries: 1, Backoff: time.Millisecond}
+}
+
+func TestDownloadResume(t *testing.T) {
+	etag, body := `"v1"`, "0123456789"
+	srv := httptest.NewServer(serveContent(&etag, &body))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "fw.hex")
+	// half of it came down last time
+	os.WriteFile(filename+".part", []byte("01234"), 0666)
+	os.WriteFile(filename+".part.validator", []byte(etag), 0666)
+
+	if err := newTestDownloader().Download(context.Background(), filename, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != body {
+		t.Errorf("got %q, want %q", data, body)
+	}
+	if _, err := os.Stat(filename + ".part.validator"); err == nil {
+		t.Error("validator left behind")
+	}
+}
+
+func TestDownloadResumeChanged(t *testing.T) {
+	etag, body := `"v2"`, "abcdefghij"
+	srv := httptest.NewServer(serveContent(&etag, &body))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "fw.hex")
+	// left over from an older version of the file, none of it can be kept
+	os.WriteFile(filename+".part", []byte("01234"), 0666)
+	os.WriteFile(filename+".part.validator", []byte(`"v1"`), 0666)
+
+	if err := newTestDownloader().Download(context.Background(), filename, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != body {
+		t.Errorf("got %q, want %q", data, body)
+	}
+}
+
+func TestDownloadResumeWithoutValidator(t *testing.T) {
+	etag, body := `"v1"`, "abcdefghij"
+	srv := httptest.NewServer(serveContent(&etag, &body))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "fw.hex")
+	os.WriteFile(filename+".part", []byte("01234"), 0666)
+
+	if err := newTestDownloader().Download(context.Background(), filename, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != body {
+		t.Errorf("got %q, want %q", data, body)
+	}
+}
+
+func TestDownloadLocalErrorNotRetried(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	// nowhere to put the .part file
+	filename := filepath.Join(t.TempDir(), "missing", "fw.hex")
+	d := newTestDownloader()
+	d.Retries = 3
+	if err := d.Download(context.Background(), filename, srv.URL); err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests != 1 {
+		t.Errorf("made %d requests, a local error shouldn't be retried", requests)
+	}
+}
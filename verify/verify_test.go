@@ -0,0 +1,157 @@
+package verify
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sumOf(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+func TestParseChecksums(t *testing.T) {
+	a, b := sumOf("a"), sumOf("b")
+	data := []byte(
+		a + "  radian_v1.2.0.hex\n" +
+			"# a comment\n" +
+			"not a checksum line\n" +
+			b + " *bixler_v1.2.0.hex\n" +
+			"abc123  too_short.hex\n")
+
+	sums := ParseChecksums(data)
+	want := map[string]string{
+		"radian_v1.2.0.hex": a,
+		"bixler_v1.2.0.hex": b,
+	}
+	if len(sums) != len(want) {
+		t.Errorf("got %v, want %v", sums, want)
+	}
+	for name, sum := range want {
+		if sums[name] != sum {
+			t.Errorf("%s = %q, want %q", name, sums[name], sum)
+		}
+	}
+}
+
+func TestNormalizeSum(t *testing.T) {
+	for in, want := range map[string]string{
+		"sha256:ABCDEF": "abcdef",
+		" abcdef\n":     "abcdef",
+		"":              "",
+	} {
+		if got := NormalizeSum(in); got != want {
+			t.Errorf("NormalizeSum(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fw.hex")
+	os.WriteFile(filename, []byte("firmware"), 0666)
+
+	if err := CheckFile(filename, "sha256:"+sumOf("firmware")); err != nil {
+		t.Error(err)
+	}
+	if err := CheckFile(filename, sumOf("something else")); err == nil {
+		t.Error("mismatched checksum passed")
+	}
+}
+
+// withKey signs with a fresh key for the length of the test
+func withKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := PUBLIC_KEY
+	PUBLIC_KEY = base64.StdEncoding.EncodeToString(pub)
+	t.Cleanup(func() { PUBLIC_KEY = old })
+	return priv
+}
+
+func TestCheckSignature(t *testing.T) {
+	priv := withKey(t)
+	data := []byte(sumOf("firmware") + "  fw.hex\n")
+	sig := ed25519.Sign(priv, data)
+
+	if err := CheckSignature(data, sig); err != nil {
+		t.Errorf("raw signature: %s", err)
+	}
+	if err := CheckSignature(data, []byte(base64.StdEncoding.EncodeToString(sig)+"\n")); err != nil {
+		t.Errorf("base64 signature: %s", err)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[0] ^= 1
+	if err := CheckSignature(tampered, sig); err == nil {
+		t.Error("signature passed for tampered data")
+	}
+	_, other, _ := ed25519.GenerateKey(nil)
+	if err := CheckSignature(data, ed25519.Sign(other, data)); err == nil {
+		t.Error("signature from another key passed")
+	}
+
+	// with a key set, a file with no checksum isn't let through
+	if err := CheckFile(filepath.Join(t.TempDir(), "fw.hex"), ""); err == nil {
+		t.Error("file without a checksum passed")
+	}
+}
+
+func TestDownloadRefetches(t *testing.T) {
+	const body = "firmware"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		sum    string
+		cached string
+		// whether the cached copy should be used as is
+		reused bool
+	}{
+		{name: "good cached copy", sum: sumOf(body), cached: body, reused: true},
+		{name: "corrupted cached copy", sum: sumOf(body), cached: "corrupted"},
+		{name: "no checksum", sum: "", cached: body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = 0
+			filename := filepath.Join(t.TempDir(), "fw.hex")
+			os.WriteFile(filename, []byte(tt.cached), 0666)
+
+			if err := Download(context.Background(), filename, srv.URL, tt.sum); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(filename); string(data) != body {
+				t.Errorf("got %q, want %q", data, body)
+			}
+			if reused := requests == 0; reused != tt.reused {
+				t.Errorf("reused = %v, want %v", reused, tt.reused)
+			}
+		})
+	}
+
+	// and a download that doesn't match is thrown away
+	filename := filepath.Join(t.TempDir(), "fw.hex")
+	if err := Download(context.Background(), filename, srv.URL, sumOf("other")); err == nil {
+		t.Error("mismatched download passed")
+	}
+	if _, err := os.Stat(filename); err == nil {
+		t.Error("mismatched download left behind")
+	}
+}